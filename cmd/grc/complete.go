@@ -0,0 +1,349 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peterh/liner"
+
+	"grc/rc"
+)
+
+func setCompleter(line *liner.State, env *rc.Env, runner *rc.Runner) {
+	line.SetWordCompleter(func(input string, pos int) (string, []string, string) {
+		runes := []rune(input)
+		if pos > len(runes) {
+			pos = len(runes)
+		}
+		left := string(runes[:pos])
+		start, candidates := completeLine(left, env, runner)
+		return left[:start], candidates, string(runes[pos:])
+	})
+}
+
+// completeLine returns candidates for the word that ends line and the
+// offset where that word starts. The line is split by the shell's lexer,
+// so quotes, redirections and subscripts are seen as the parser sees them.
+func completeLine(line string, env *rc.Env, runner *rc.Runner) (int, []string) {
+	toks := rc.Tokens(line)
+	start := len(line)
+	prefix := ""
+	quoted := false
+	hasWord := false
+	if n := len(toks); n > 0 {
+		if cur := toks[n-1]; cur.End == len(line) && (cur.Tok == rc.TokWord || cur.Text != "") {
+			toks = toks[:n-1]
+			start, prefix, quoted, hasWord = cur.Start, cur.Text, cur.Quoted, true
+		}
+	}
+	// Words that touch, as in a'b c, are one word to the parser.
+	for hasWord && len(toks) > 0 {
+		prev := toks[len(toks)-1]
+		if prev.Tok != rc.TokWord || prev.End != start {
+			break
+		}
+		toks = toks[:len(toks)-1]
+		start, prefix, quoted = prev.Start, prev.Text+prefix, quoted || prev.Quoted
+	}
+	var prev rc.Token
+	if len(toks) > 0 {
+		prev = toks[len(toks)-1]
+	}
+	adjacent := len(toks) > 0 && prev.End == start
+
+	switch {
+	case adjacent && isDollar(prev.Tok) && !quoted:
+		return start, completeVars(prefix, env)
+	case adjacent && prev.Tok == rc.TokSub && len(toks) >= 3 && isDollar(toks[len(toks)-3].Tok):
+		return start, completeIndex(toks[len(toks)-2].Text, prefix, env)
+	case len(toks) > 0 && (prev.Tok == rc.TokRedir || prev.Tok == rc.TokSRedir):
+		return start, quoteCandidates(completePath(fileSystem(runner), prefix), quoted)
+	case startsCommand(toks):
+		if !hasWord {
+			return start, nil
+		}
+		if quoted || strings.Contains(prefix, "/") || strings.HasPrefix(prefix, ".") {
+			return start, quoteCandidates(completePath(fileSystem(runner), prefix), quoted)
+		}
+		return start, completeCommand(prefix, env, runner)
+	}
+
+	words := commandWords(toks)
+	words = append(words, prefix)
+	switch words[0] {
+	case "fg", "bg", "kill":
+		return start, completeJobs(prefix, runner, false)
+	case "wait":
+		return start, completeJobs(prefix, runner, true)
+	}
+	if runner != nil {
+		if candidates, ok := runner.Complete(words, len(words)); ok && len(candidates) > 0 {
+			return start, candidates
+		}
+	}
+	if !hasWord {
+		return start, nil
+	}
+	return start, quoteCandidates(completePath(fileSystem(runner), prefix), quoted)
+}
+
+func isDollar(tok int) bool {
+	return tok == '$' || tok == rc.TokCount || tok == rc.TokFlat
+}
+
+// startsCommand reports whether a word after toks is in command position.
+func startsCommand(toks []rc.Token) bool {
+	if len(toks) == 0 {
+		return true
+	}
+	switch toks[len(toks)-1].Tok {
+	case ';', '&', '\n', '{', '}', ')', rc.TokPipe, rc.TokAndAnd, rc.TokOrOr, rc.TokBang, rc.TokSubshell:
+		return true
+	case '(':
+		if len(toks) >= 2 {
+			switch toks[len(toks)-2].Tok {
+			case rc.TokIf, rc.TokWhile:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commandWords returns the words of the command that toks ends in.
+func commandWords(toks []rc.Token) []string {
+	i := len(toks)
+	for i > 0 && !startsCommand(toks[:i]) {
+		i--
+	}
+	var words []string
+	for _, t := range toks[i:] {
+		if t.Tok == rc.TokWord {
+			words = append(words, t.Text)
+		}
+	}
+	if len(words) == 0 {
+		return []string{""}
+	}
+	return words
+}
+
+// quoteCandidates quotes the paths that need it, or all of them when the
+// word being completed is quoted. A directory keeps its quote open so
+// that the path can be extended.
+func quoteCandidates(paths []string, quoted bool) []string {
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		q := rc.Quote(p)
+		if !quoted && q == p {
+			out = append(out, p)
+			continue
+		}
+		q = "'" + strings.ReplaceAll(p, "'", "''") + "'"
+		if strings.HasSuffix(p, string(os.PathSeparator)) {
+			q = strings.TrimSuffix(q, "'")
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+func completeVars(prefix string, env *rc.Env) []string {
+	if env == nil {
+		return nil
+	}
+	var out []string
+	for name := range env.Snapshot() {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// completeIndex offers the valid subscripts of $name.
+func completeIndex(name, prefix string, env *rc.Env) []string {
+	if env == nil {
+		return nil
+	}
+	var out []string
+	for i := range env.Get(name) {
+		idx := strconv.Itoa(i + 1)
+		if strings.HasPrefix(idx, prefix) {
+			out = append(out, idx)
+		}
+	}
+	return out
+}
+
+// completeJobs offers %id job specs, or process ids when pids is set.
+func completeJobs(prefix string, runner *rc.Runner, pids bool) []string {
+	if runner == nil {
+		return nil
+	}
+	var out []string
+	for _, job := range runner.ListJobs() {
+		if pids {
+			for _, pid := range job.Pids {
+				if s := strconv.Itoa(pid); strings.HasPrefix(s, prefix) {
+					out = append(out, s)
+				}
+			}
+			continue
+		}
+		if s := "%" + strconv.Itoa(job.ID); strings.HasPrefix(s, prefix) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func completeCommand(prefix string, env *rc.Env, runner *rc.Runner) []string {
+	seen := make(map[string]struct{})
+	var out []string
+	var builtins []string
+	if runner != nil && runner.Builtins != nil {
+		for name := range runner.Builtins {
+			builtins = append(builtins, name)
+		}
+	} else {
+		builtins = rc.BuiltinNames()
+	}
+	for _, name := range builtins {
+		if strings.HasPrefix(name, prefix) {
+			seen[name] = struct{}{}
+			out = append(out, name)
+		}
+	}
+	if env != nil {
+		for _, name := range env.FuncNames() {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			out = append(out, name)
+		}
+	}
+	for _, name := range completePathCommands(prefix, env, runner) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func completePathCommands(prefix string, env *rc.Env, runner *rc.Runner) []string {
+	fsys := fileSystem(runner)
+	dirs := pathListFromEnv(env)
+	seen := make(map[string]struct{})
+	var out []string
+	for _, dir := range dirs {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+				continue
+			}
+			full := filepath.Join(dir, name)
+			if !isExecutable(fsys, full, entry) {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func completePath(fsys rc.FS, prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	searchDir := dir
+	if searchDir == "" {
+		searchDir = "."
+	}
+	entries, err := fsys.ReadDir(searchDir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		candidate := name
+		if dir != "" {
+			candidate = dir + name
+		}
+		if entry.IsDir() {
+			candidate += string(os.PathSeparator)
+		}
+		out = append(out, candidate)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func isExecutable(fsys rc.FS, path string, entry os.DirEntry) bool {
+	info, err := entry.Info()
+	if err != nil {
+		return false
+	}
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		return false
+	}
+	if _, err := fsys.Stat(path); err != nil {
+		return false
+	}
+	return true
+}
+
+// fileSystem returns the files the runner sees, so that completion follows
+// its working directory rather than the process's.
+func fileSystem(runner *rc.Runner) rc.FS {
+	if runner == nil {
+		return &rc.OSFS{}
+	}
+	return runner.FileSystem()
+}
+
+func pathListFromEnv(env *rc.Env) []string {
+	if env != nil {
+		if vals := env.Get("path"); len(vals) > 0 {
+			return vals
+		}
+	}
+	if p := os.Getenv("PATH"); p != "" {
+		return strings.Split(p, string(os.PathListSeparator))
+	}
+	return []string{""}
+}
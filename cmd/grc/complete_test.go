@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"grc/rc"
+)
+
+func chdirTemp(t *testing.T, files ...string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+			continue
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+}
+
+func TestCompleteLineContexts(t *testing.T) {
+	chdirTemp(t, "My Doc.txt", "My Dir/", "notes.txt")
+	env := rc.New().Env()
+	env.Set("path", []string{"/a", "/b", "/c"})
+	env.Set("pager", []string{"less"})
+	cases := []struct {
+		line  string
+		start int
+		want  string
+	}{
+		{"echo 'My D", 5, "'My Dir/ 'My Doc.txt'"},
+		{"echo My", 5, "'My Dir/ 'My Doc.txt'"},
+		{"echo My' D", 5, "'My Dir/ 'My Doc.txt'"},
+		{"echo n'ot", 5, "'notes.txt'"},
+		{"cat <no", 5, "notes.txt"},
+		{"cat >[2] no", 9, "notes.txt"},
+		{"echo $pa", 6, "pager path"},
+		{"echo $#pa", 7, "pager path"},
+		{"echo $path(", 11, "1 2 3"},
+		{"echo $path(2", 11, "2"},
+		{"ls; echo x |newp", 12, "newpgrp"},
+		{"fg %", 3, "%1 %2"},
+		{"bg ", 3, "%1 %2"},
+		{"wait 4", 5, "42 43"},
+	}
+	runner := &rc.Runner{Env: env, Jobs: map[int]*rc.Job{
+		1: {ID: 1, Pids: []int{42}},
+		2: {ID: 2, Pids: []int{43, 7}},
+	}}
+	for _, c := range cases {
+		start, got := completeLine(c.line, env, runner)
+		if start != c.start || strings.Join(got, " ") != c.want {
+			t.Fatalf("completeLine(%q) = %d %q, want %d %q", c.line, start, got, c.start, c.want)
+		}
+	}
+}
@@ -1,37 +1,45 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/peterh/liner"
 	"golang.org/x/sys/unix"
 	"golang.org/x/term"
 
-	"grc/internal/eval"
-	"grc/internal/parse"
+	"grc/rc"
 )
 
 const version = "dev"
 
 func main() {
 	opts, args := parseArgs(os.Args[1:])
-	env := eval.NewEnv(nil)
-	initEnv(env)
-	initStar(env, os.Args[0], args)
+	if strings.HasPrefix(os.Args[0], "-") {
+		opts.login = true
+	}
+	in := newInterpreter(opts)
+	initEnv(in)
+	in.SetArgs(os.Args[0], args)
+	if opts.tracefile != "" {
+		startTrace(in, opts.tracefile)
+	}
 
 	if opts.command != "" {
-		runCommand(opts, env, opts.command)
+		runStartup(opts, in)
+		runCommand(opts, in, opts.command)
 		return
 	}
 	if len(args) > 0 && !opts.readStdin {
-		runDotFile(opts, env, args)
+		runStartup(opts, in)
+		runDotFile(opts, in, args)
 		return
 	}
 	interactive := opts.interactive
@@ -39,47 +47,115 @@ func main() {
 		interactive = term.IsTerminal(int(os.Stdin.Fd()))
 	}
 	if interactive {
-		runInteractive(opts, env)
+		runInteractive(opts, in)
 		return
 	}
-	runScript(opts, env, os.Stdin)
+	runStartup(opts, in)
+	runScript(opts, in, os.Stdin)
 }
 
-func runScript(opts options, env *eval.Env, rd io.Reader) {
-	ast, err := parse.ParseAll(rd)
+// rcfileVar names the environment variable holding the file an
+// interactive shell runs at startup.
+const rcfileVar = "GRCRC"
+
+// runStartup runs the startup files through the . builtin in the
+// top-level environment: $home/lib/profile for a login shell, then, for an
+// interactive one, the file named by $GRCRC. Errors in them are reported
+// and the shell starts anyway.
+func runStartup(opts options, in *rc.Interpreter) {
+	if opts.noexec {
+		return
+	}
+	env := in.Env()
+	var files []string
+	if opts.login {
+		if home := env.Get("home"); len(home) > 0 && home[0] != "" {
+			profile := filepath.Join(home[0], "lib", "profile")
+			if _, err := os.Stat(profile); err == nil {
+				files = append(files, profile)
+			}
+		}
+	}
+	if in.Runner().Flag('i') {
+		if rc := env.Get(rcfileVar); len(rc) > 0 && rc[0] != "" {
+			files = append(files, rc[0])
+		}
+	}
+	for _, file := range files {
+		in.RunFile(file)
+		exitIfRequested(in)
+	}
+}
+
+// newInterpreter returns the shell's interpreter, reading and writing the
+// process's standard files.
+func newInterpreter(opts options) *rc.Interpreter {
+	in := rc.New()
+	in.Stdin, in.Stdout, in.Stderr = os.Stdin, os.Stdout, os.Stderr
+	runner := in.Runner()
+	runner.Trace = opts.trace
+	runner.TraceWriter = os.Stderr
+	if self, err := os.Executable(); err == nil {
+		runner.SelfPath = self
+	}
+	for i := 0; i < len(opts.flags); i++ {
+		in.SetFlag(opts.flags[i], true)
+	}
+	return in
+}
+
+// startTrace empties path and names it in $tracefile, so that it gets the
+// JSON trace of this shell and, as they append to it, of its subshells.
+func startTrace(in *rc.Interpreter, path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	f, err := os.Create(path)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "rc:", err)
 		os.Exit(1)
 	}
-	plan, err := eval.BuildPlan(ast, env)
+	_ = f.Close()
+	in.Set("tracefile", []string{path})
+}
+
+// exitIfRequested runs the exit handler and exits once the exit builtin
+// has run.
+func exitIfRequested(in *rc.Interpreter) {
+	if status, ok := in.Exited(); ok {
+		in.Close()
+		os.Exit(status.Code())
+	}
+}
+
+func runScript(opts options, in *rc.Interpreter, rd io.Reader) {
+	prog, err := in.Compile(in.Runner().Input(rd, os.Stderr))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	if opts.printplan {
-		fmt.Fprint(os.Stderr, eval.DumpPlan(plan))
+		fmt.Fprint(os.Stderr, prog)
 	}
 	if opts.noexec {
 		os.Exit(0)
 	}
-	runner := &eval.Runner{Env: env, Trace: opts.trace, TraceWriter: os.Stderr}
-	if self, err := os.Executable(); err == nil {
-		runner.SelfPath = self
-	}
-	result := runner.RunPlan(plan, os.Stdin, os.Stdout, os.Stderr)
-	if runner.ExitRequested() {
-		os.Exit(runner.ExitCode())
-	}
-	if result.Status != 0 {
-		os.Exit(result.Status)
+	status := in.Exec(prog)
+	in.Close()
+	exitIfRequested(in)
+	if code := status.Code(); code != 0 {
+		os.Exit(code)
 	}
 }
 
-func runCommand(opts options, env *eval.Env, cmd string) {
-	runScript(opts, env, strings.NewReader(cmd))
+func runCommand(opts options, in *rc.Interpreter, cmd string) {
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	runScript(opts, in, strings.NewReader(cmd))
 }
 
-func runDotFile(opts options, env *eval.Env, args []string) {
+func runDotFile(opts options, in *rc.Interpreter, args []string) {
 	if opts.noexec {
 		if len(args) == 0 {
 			return
@@ -90,63 +166,31 @@ func runDotFile(opts options, env *eval.Env, args []string) {
 			os.Exit(1)
 		}
 		defer f.Close()
-		_, err = parse.ParseAll(f)
+		_, err = in.Compile(f)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 		os.Exit(0)
 	}
-	runner := &eval.Runner{Env: env, Trace: opts.trace, TraceWriter: os.Stderr}
-	if self, err := os.Executable(); err == nil {
-		runner.SelfPath = self
-	}
-	status := runner.RunPlan(
-		&eval.ExecPlan{Kind: eval.PlanCmd, Argv: append([]string{"."}, args...)},
-		os.Stdin,
-		os.Stdout,
-		os.Stderr,
-	)
-	if runner.ExitRequested() {
-		os.Exit(runner.ExitCode())
-	}
-	if status.Status != 0 {
-		os.Exit(status.Status)
+	status := in.RunFile(args[0], args[1:]...)
+	in.Close()
+	exitIfRequested(in)
+	if code := status.Code(); code != 0 {
+		os.Exit(code)
 	}
 }
 
-func runInteractive(opts options, env *eval.Env) {
-	line := liner.NewLiner()
-	line.SetCtrlCAborts(true)
-	defer func() {
-		if line != nil {
-			line.Close()
-		}
-	}()
-
-	runner := &eval.Runner{
-		Env:         env,
-		Trace:       opts.trace,
-		TraceWriter: os.Stderr,
-	}
-	if self, err := os.Executable(); err == nil {
-		runner.SelfPath = self
-	}
-	line.SetCompleter(func(input string) []string {
-		return completeLine(input, env, runner)
-	})
-	runner.JobControl = false
-	historyPath := historyPathFromEnv(env)
-	var historyLines []string
-	if historyPath != "" {
-		if f, err := os.Open(historyPath); err == nil {
-			defer f.Close()
-			_, _ = line.ReadHistory(f)
-		}
-	}
-	ttyfd := int(os.Stdin.Fd())
+func runInteractive(opts options, in *rc.Interpreter) {
+	runner := in.Runner()
+	env := in.Env()
+	runner.SetFlag('i', true)
+	ttyfd := terminalFD()
 	runner.Interactive = true
 	runner.TTYFD = ttyfd
+	runner.JobControl = ttyfd > 0
+	// The line editor switches the terminal to raw mode as it starts, so
+	// the shell's own modes are read before it.
 	var origState *term.State
 	if ttyfd > 0 {
 		if st, err := term.GetState(ttyfd); err == nil {
@@ -154,6 +198,21 @@ func runInteractive(opts options, env *eval.Env) {
 		}
 	}
 	initJobControl(runner)
+	runner.SaveTerminalModes()
+	runStartup(opts, in)
+
+	line := liner.NewLiner()
+	line.SetCtrlCAborts(true)
+	defer func() {
+		if line != nil {
+			line.Close()
+		}
+	}()
+	setCompleter(line, env, runner)
+	historyLines := loadHistory(env)
+	for _, h := range historyLines {
+		line.AppendHistory(h)
+	}
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt)
 	defer signal.Stop(sigc)
@@ -169,6 +228,8 @@ func runInteractive(opts options, env *eval.Env) {
 	}()
 	for {
 		if !opts.noexec {
+			runner.NotifyJobs(os.Stderr)
+			runner.HandleSignals(os.Stdin, os.Stdout, os.Stderr)
 			if _, ok := env.GetFunc("prompt"); ok {
 				_ = runner.CallFunc("prompt", nil, os.Stdin, os.Stdout, os.Stderr)
 			}
@@ -186,73 +247,68 @@ func runInteractive(opts options, env *eval.Env) {
 			fmt.Fprintln(os.Stderr, err)
 			break
 		}
+		if runner.Flag('v') {
+			fmt.Fprintln(os.Stderr, input)
+		}
 		if strings.TrimSpace(input) == "" {
 			continue
 		}
 		line.AppendHistory(input)
 		historyLines = append(historyLines, input)
-		appendHistory(env, input)
 
-		ast, err := parse.ParseAll(strings.NewReader(input + "\n"))
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			continue
-		}
-		plan, err := eval.BuildPlan(ast, env)
+		prog, err := in.Compile(strings.NewReader(input + "\n"))
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
+			var se *rc.SyntaxError
+			if errors.As(err, &se) {
+				recordHistory(runner, input, "syntax error")
+			} else {
+				recordHistory(runner, input, "1")
+			}
 			continue
 		}
 		if opts.printplan {
-			fmt.Fprint(os.Stderr, eval.DumpPlan(plan))
+			fmt.Fprint(os.Stderr, prog)
 		}
 		if opts.noexec {
+			recordHistory(runner, input, "")
 			continue
 		}
 		line.Close()
 		if origState != nil {
 			_ = term.Restore(ttyfd, origState)
 		}
-		result := runner.RunPlan(plan, os.Stdin, os.Stdout, os.Stderr)
+		status := in.Exec(prog)
+		recordHistory(runner, input, status.String())
 		line = liner.NewLiner()
 		line.SetCtrlCAborts(true)
-		line.SetCompleter(func(input string) []string {
-			return completeLine(input, env, runner)
-		})
-		if historyPath != "" {
-			if f, err := os.Open(historyPath); err == nil {
-				_, _ = line.ReadHistory(f)
-				_ = f.Close()
-			}
-		}
+		setCompleter(line, env, runner)
 		for _, h := range historyLines {
 			line.AppendHistory(h)
 		}
-		if runner.ExitRequested() {
-			os.Exit(runner.ExitCode())
-		}
-		_ = result
-	}
-
-	if historyPath != "" {
-		if f, err := os.Create(historyPath); err == nil {
-			defer f.Close()
-			_, _ = line.WriteHistory(f)
-		}
+		exitIfRequested(in)
 	}
+	in.Close()
 }
 
 type options struct {
 	noexec              bool
 	printplan           bool
 	trace               bool
+	tracefile           string
 	readStdin           bool
 	interactive         bool
 	interactiveForced   bool
 	interactiveDisabled bool
+	login               bool
 	command             string
+	flags               string
 }
 
+// runnerFlags are the command line flags the runner keeps, for the flag
+// builtin and for subshells. The others only steer startup.
+const runnerFlags = "deiovx"
+
 func parseArgs(args []string) (options, []string) {
 	var opts options
 	var rest []string
@@ -267,6 +323,9 @@ func parseArgs(args []string) (options, []string) {
 			break
 		}
 		for j := 1; j < len(arg); j++ {
+			if strings.IndexByte(runnerFlags, arg[j]) >= 0 {
+				opts.flags += string(arg[j])
+			}
 			switch arg[j] {
 			case 'c':
 				if i+1 < len(args) {
@@ -279,6 +338,11 @@ func parseArgs(args []string) (options, []string) {
 				opts.printplan = true
 			case 'x':
 				opts.trace = true
+			case 'X':
+				if i+1 < len(args) {
+					opts.tracefile = args[i+1]
+					i++
+				}
 			case 's':
 				opts.readStdin = true
 			case 'i':
@@ -288,45 +352,43 @@ func parseArgs(args []string) (options, []string) {
 				opts.interactive = false
 				opts.interactiveDisabled = true
 			case 'l':
-				// login flag; no behavior yet
-			case 'd', 'e', 'o', 'v':
-				// reserved: parser/exec flags in rc
-			default:
-				// unknown flag ignored
+				opts.login = true
 			}
 		}
 	}
 	return opts, rest
 }
 
-func historyPathFromEnv(env *eval.Env) string {
-	if env == nil {
-		return ""
+// loadHistory returns the commands of the $history file, oldest first,
+// for the line editor.
+func loadHistory(env *rc.Env) []string {
+	store := rc.History(env)
+	if store == nil {
+		return nil
+	}
+	entries, err := store.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rc: history:", err)
+		return nil
 	}
-	vals := env.Get("history")
-	if len(vals) == 0 || vals[0] == "" {
-		return ""
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.Line)
 	}
-	return vals[0]
+	return lines
 }
 
-func appendHistory(env *eval.Env, input string) {
-	path := historyPathFromEnv(env)
-	if path == "" {
+// recordHistory adds input and the status it left to the $history file.
+func recordHistory(runner *rc.Runner, input, status string) {
+	store := rc.History(runner.Env)
+	if store == nil || shouldSkipHistory(input) {
 		return
 	}
-	if shouldSkipHistory(input) {
-		return
-	}
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o666)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	if !strings.HasSuffix(input, "\n") {
-		input += "\n"
+	dir, _ := runner.WorkingDir()
+	e := rc.HistoryEntry{Time: time.Now(), Status: status, Dir: dir, Line: input}
+	if err := store.Add(e); err != nil {
+		fmt.Fprintln(os.Stderr, "rc: history:", err)
 	}
-	_, _ = f.WriteString(input)
 }
 
 func shouldSkipHistory(input string) bool {
@@ -343,7 +405,7 @@ func shouldSkipHistory(input string) bool {
 	return true
 }
 
-func promptsFromEnv(env *eval.Env) (string, string) {
+func promptsFromEnv(env *rc.Env) (string, string) {
 	prompt1 := "; "
 	prompt2 := ""
 	if env == nil {
@@ -379,280 +441,19 @@ func readCommand(line *liner.State, prompt1, prompt2 string) (string, error) {
 	}
 }
 
+// needsMoreInput reports whether s stops inside a construct that a further
+// line could finish, which is when rc prompts with $prompt(2).
 func needsMoreInput(s string) bool {
-	inQuote := false
-	brace := 0
-	paren := 0
-	escaped := false
-	for _, r := range s {
-		if escaped {
-			escaped = false
-			continue
-		}
-		if r == '\\' && !inQuote {
-			escaped = true
-			continue
-		}
-		if r == '\'' {
-			inQuote = !inQuote
-			continue
-		}
-		if inQuote {
-			continue
-		}
-		switch r {
-		case '{':
-			brace++
-		case '}':
-			if brace > 0 {
-				brace--
-			}
-		case '(':
-			paren++
-		case ')':
-			if paren > 0 {
-				paren--
-			}
-		}
-	}
-	if escaped {
-		return true
-	}
-	return brace > 0 || paren > 0 || inQuote
-}
-
-func completeLine(line string, env *eval.Env, runner *eval.Runner) []string {
-	if line == "" {
-		return nil
-	}
-	if strings.Count(line, "'")%2 == 1 {
-		return nil
-	}
-	token, start := lastToken(line)
-	if token == "" {
-		return nil
-	}
-	if strings.HasPrefix(token, "$") {
-		return completeVars(token, env)
-	}
-	if strings.Contains(token, "/") || strings.HasPrefix(token, ".") {
-		return completePath(token)
-	}
-	if isCommandPosition(line, start) {
-		return completeCommand(token, env, runner)
-	}
-	return completePath(token)
-}
-
-func lastToken(line string) (string, int) {
-	i := len(line)
-	for i > 0 {
-		switch line[i-1] {
-		case ' ', '\t', '\n':
-			return line[i:], i
-		}
-		i--
-	}
-	return line, 0
-}
-
-func isCommandPosition(line string, start int) bool {
-	i := start - 1
-	for i >= 0 {
-		switch line[i] {
-		case ' ', '\t', '\n':
-			i--
-			continue
-		case ';', '|', '&', '(', ')', '{', '}':
-			return true
-		default:
-			return false
-		}
-	}
-	return true
-}
-
-func completeVars(prefix string, env *eval.Env) []string {
-	if env == nil {
-		return nil
-	}
-	raw := strings.TrimPrefix(prefix, "$")
-	var out []string
-	for name := range env.Snapshot() {
-		if !strings.HasPrefix(name, raw) {
-			continue
-		}
-		out = append(out, "$"+name)
-	}
-	sort.Strings(out)
-	return out
-}
-
-func completeCommand(prefix string, env *eval.Env, runner *eval.Runner) []string {
-	seen := make(map[string]struct{})
-	var out []string
-	var builtins []string
-	if runner != nil && runner.Builtins != nil {
-		for name := range runner.Builtins {
-			builtins = append(builtins, name)
-		}
-	} else {
-		builtins = eval.BuiltinNames()
-	}
-	for _, name := range builtins {
-		if strings.HasPrefix(name, prefix) {
-			seen[name] = struct{}{}
-			out = append(out, name)
-		}
-	}
-	if env != nil {
-		for _, name := range env.FuncNames() {
-			if !strings.HasPrefix(name, prefix) {
-				continue
-			}
-			if _, ok := seen[name]; ok {
-				continue
-			}
-			seen[name] = struct{}{}
-			out = append(out, name)
-		}
-	}
-	for _, name := range completePathCommands(prefix, env) {
-		if _, ok := seen[name]; ok {
-			continue
-		}
-		seen[name] = struct{}{}
-		out = append(out, name)
-	}
-	sort.Strings(out)
-	return out
-}
-
-func completePathCommands(prefix string, env *eval.Env) []string {
-	dirs := pathListFromEnv(env)
-	seen := make(map[string]struct{})
-	var out []string
-	for _, dir := range dirs {
-		if dir == "" {
-			dir = "."
-		}
-		entries, err := os.ReadDir(dir)
-		if err != nil {
-			continue
-		}
-		for _, entry := range entries {
-			name := entry.Name()
-			if !strings.HasPrefix(name, prefix) {
-				continue
-			}
-			if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
-				continue
-			}
-			full := filepath.Join(dir, name)
-			if !isExecutable(full, entry) {
-				continue
-			}
-			if _, ok := seen[name]; ok {
-				continue
-			}
-			seen[name] = struct{}{}
-			out = append(out, name)
-		}
-	}
-	sort.Strings(out)
-	return out
-}
-
-func completePath(prefix string) []string {
-	dir, base := filepath.Split(prefix)
-	searchDir := dir
-	if searchDir == "" {
-		searchDir = "."
-	}
-	entries, err := os.ReadDir(searchDir)
-	if err != nil {
-		return nil
-	}
-	var out []string
-	for _, entry := range entries {
-		name := entry.Name()
-		if !strings.HasPrefix(name, base) {
-			continue
-		}
-		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
-			continue
-		}
-		candidate := name
-		if dir != "" {
-			candidate = dir + name
-		}
-		if entry.IsDir() {
-			candidate += string(os.PathSeparator)
-		}
-		out = append(out, candidate)
-	}
-	sort.Strings(out)
-	return out
-}
-
-func isExecutable(path string, entry os.DirEntry) bool {
-	info, err := entry.Info()
-	if err != nil {
-		return false
-	}
-	if !info.Mode().IsRegular() {
-		return false
-	}
-	if info.Mode().Perm()&0o111 == 0 {
-		return false
-	}
-	if _, err := os.Stat(path); err != nil {
-		return false
-	}
-	return true
+	return rc.Incomplete(s)
 }
 
-func pathListFromEnv(env *eval.Env) []string {
-	if env != nil {
-		if vals := env.Get("path"); len(vals) > 0 {
-			return vals
-		}
-	}
-	if p := os.Getenv("PATH"); p != "" {
-		return strings.Split(p, string(os.PathListSeparator))
-	}
-	return []string{""}
-}
-
-func initEnv(env *eval.Env) {
-	if env == nil {
-		return
-	}
-	env.Set("ifs", []string{" ", "\t", "\n"})
-	env.Set("nl", []string{"\n"})
+func initEnv(in *rc.Interpreter) {
+	env := in.Env()
 	env.Set("prompt", []string{"; ", ""})
-	env.Set("tab", []string{"\t"})
 	env.Set("pid", []string{fmt.Sprintf("%d", os.Getpid())})
 	env.Set("version", []string{version})
-	for _, kv := range os.Environ() {
-		if kv == "" {
-			continue
-		}
-		parts := strings.SplitN(kv, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := parts[0]
-		val := parts[1]
-		if key == "PATH" {
-			if val == "" {
-				env.Set("path", nil)
-			} else {
-				env.Set("path", strings.Split(val, string(os.PathListSeparator)))
-			}
-			continue
-		}
-		env.Set(key, []string{val})
+	if err := in.ImportEnviron(os.Environ()); err != nil {
+		fmt.Fprintln(os.Stderr, "rc:", err)
 	}
 	if vals := env.Get("home"); len(vals) == 0 || vals[0] == "" {
 		if home, err := os.UserHomeDir(); err == nil && home != "" {
@@ -667,6 +468,9 @@ func initEnv(env *eval.Env) {
 	if vals := env.Get("prompt"); len(vals) == 0 {
 		env.Set("prompt", []string{"; ", ""})
 	}
+	if wd, err := in.Runner().WorkingDir(); err == nil {
+		env.Set("pwd", []string{wd})
+	}
 	if vals := env.Get("ifs"); len(vals) == 0 {
 		env.Set("ifs", []string{" ", "\t", "\n"})
 	}
@@ -684,15 +488,21 @@ func initEnv(env *eval.Env) {
 	}
 }
 
-func initStar(env *eval.Env, argv0 string, args []string) {
-	if env == nil {
-		return
+// terminalFD returns a descriptor for the terminal on standard input, kept
+// clear of the low descriptors that redirections use, or 0 when standard
+// input is not a terminal.
+func terminalFD() int {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return 0
+	}
+	fd, err := unix.FcntlInt(os.Stdin.Fd(), unix.F_DUPFD_CLOEXEC, 10)
+	if err != nil {
+		return 0
 	}
-	env.Set("0", []string{argv0})
-	env.Set("*", args)
+	return fd
 }
 
-func initJobControl(runner *eval.Runner) {
+func initJobControl(runner *rc.Runner) {
 	ttyfd := runner.TTYFD
 	if ttyfd <= 0 {
 		return
@@ -710,7 +520,12 @@ func initJobControl(runner *eval.Runner) {
 		}
 		_ = unix.Kill(-shellPgid, unix.SIGTTIN)
 	}
+	// Catching rather than ignoring the stop signals keeps the shell
+	// running without passing the disposition on to its children.
+	// SIGTTOU is the exception: handing the terminal over or taking it
+	// back only works while it is ignored, and resetting it after each
+	// handoff would race with the next, so it stays ignored.
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTSTP, syscall.SIGTTIN)
 	signal.Ignore(syscall.SIGTTOU)
-	defer signal.Reset(syscall.SIGTTOU)
 	_ = unix.IoctlSetPointerInt(ttyfd, unix.TIOCSPGRP, shellPgid)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunStartup(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib", "profile"), []byte("profile=1\n"), 0o644); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+	rcfile := filepath.Join(dir, "rcfile")
+	if err := os.WriteFile(rcfile, []byte("rcfile=1\n"), 0o644); err != nil {
+		t.Fatalf("write rcfile: %v", err)
+	}
+	in := newInterpreter(options{})
+	env := in.Env()
+	env.Set("home", []string{dir})
+	env.Set(rcfileVar, []string{rcfile})
+
+	runStartup(options{login: true}, in)
+	if len(env.Get("profile")) == 0 || len(env.Get("rcfile")) != 0 {
+		t.Fatalf("a login shell should run only the profile: %q %q", env.Get("profile"), env.Get("rcfile"))
+	}
+
+	env.Unset("profile")
+	in.SetFlag('i', true)
+	runStartup(options{}, in)
+	if len(env.Get("profile")) != 0 || len(env.Get("rcfile")) == 0 {
+		t.Fatalf("an interactive shell should run only the rcfile: %q %q", env.Get("profile"), env.Get("rcfile"))
+	}
+}
+
+func TestParseArgsRunnerFlags(t *testing.T) {
+	opts, rest := parseArgs([]string{"-evc", "flag c", "-n", "a"})
+	if opts.flags != "ev" || opts.command != "flag c" || !opts.noexec {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+	if len(rest) != 1 || rest[0] != "a" {
+		t.Fatalf("unexpected arguments: %q", rest)
+	}
+	in := newInterpreter(opts)
+	for _, c := range []byte("cn") {
+		if in.Runner().Flag(c) {
+			t.Fatalf("expected flag %c to stay unset", c)
+		}
+	}
+	if !in.Runner().Flag('e') || !in.Runner().Flag('v') {
+		t.Fatalf("expected flags e and v to be set")
+	}
+}
@@ -4,10 +4,8 @@ go 1.21
 
 require (
 	github.com/peterh/liner v1.2.2
+	golang.org/x/sys v0.22.0
 	golang.org/x/term v0.22.0
 )
 
-require (
-	github.com/mattn/go-runewidth v0.0.3 // indirect
-	golang.org/x/sys v0.22.0 // indirect
-)
+require github.com/mattn/go-runewidth v0.0.3 // indirect
@@ -1,9 +1,11 @@
 package eval
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"syscall"
@@ -18,21 +20,29 @@ type Builtin func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *R
 
 func defaultBuiltins() map[string]Builtin {
 	return map[string]Builtin{
-		"apid":    builtinAPID,
-		"bg":      builtinBG,
-		"cd":      builtinCD,
-		".":       builtinDot,
-		"exec":    builtinExec,
-		"fg":      builtinFG,
-		"jobs":    builtinJobs,
-		"newpgrp": builtinNewpgrp,
-		"pwd":     builtinPWD,
-		"exit":    builtinExit,
-		"eval":    builtinEval,
-		"which":   builtinWhich,
-		"shift":   builtinShift,
-		"return":  builtinReturn,
-		"wait":    builtinWait,
+		"apid":     builtinAPID,
+		"bg":       builtinBG,
+		"cd":       builtinCD,
+		"complete": builtinComplete,
+		".":        builtinDot,
+		"exec":     builtinExec,
+		"fg":       builtinFG,
+		"flag":     builtinFlag,
+		"history":  builtinHistory,
+		"jobs":     builtinJobs,
+		"kill":     builtinKill,
+		"limit":    builtinLimit,
+		"newpgrp":  builtinNewpgrp,
+		"pwd":      builtinPWD,
+		"exit":     builtinExit,
+		"eval":     builtinEval,
+		"whatis":   builtinWhatis,
+		"which":    builtinWhich,
+		"shift":    builtinShift,
+		"ulimit":   builtinUlimit,
+		"umask":    builtinUmask,
+		"return":   builtinReturn,
+		"wait":     builtinWait,
 	}
 }
 
@@ -46,17 +56,52 @@ func BuiltinNames() []string {
 	return names
 }
 
+// SetFunc defines the function name, with body a brace block, in the
+// runner's environment, as fn does.
+func (r *Runner) SetFunc(name string, body *parse.Node) {
+	r.Env.SetFunc(name, body)
+	r.watchSignals()
+}
+
+// SetBuiltin adds fn to the runner's builtins under name, replacing any
+// builtin already there. A nil fn removes the builtin.
+func (r *Runner) SetBuiltin(name string, fn Builtin) {
+	if r.Builtins == nil {
+		r.Builtins = defaultBuiltins()
+	}
+	if fn == nil {
+		delete(r.Builtins, name)
+		return
+	}
+	r.Builtins[name] = fn
+}
+
+// builtinCD changes directory. A relative name that does not start with
+// . or .. is looked up in each $cdpath entry, an empty entry meaning the
+// current directory, and the directory found is printed when it came from
+// a non-empty entry. cd - returns to $oldpwd. $pwd and $oldpwd keep the
+// logical path, as reached through symbolic links.
 func builtinCD(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
 	_ = stdin
-	_ = stdout
+	if r == nil || r.Env == nil {
+		return 1
+	}
+	env := r.Env
 	var dir string
-	if len(args) > 1 {
-		dir = args[1]
-	} else {
-		if r != nil && r.Env != nil {
-			if home := r.Env.Get("home"); len(home) > 0 {
-				dir = home[0]
-			}
+	show := false
+	switch {
+	case len(args) > 1 && args[1] == "-":
+		old := env.Get("oldpwd")
+		if len(old) == 0 || old[0] == "" {
+			fmt.Fprintln(stderr, "cd: no previous directory")
+			return 1
+		}
+		dir, show = old[0], true
+	case len(args) > 1:
+		dir, show = cdTarget(r.FileSystem(), args[1], env)
+	default:
+		if home := env.Get("home"); len(home) > 0 {
+			dir = home[0]
 		}
 		if dir == "" {
 			h, err := os.UserHomeDir()
@@ -67,18 +112,65 @@ func builtinCD(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runn
 			dir = h
 		}
 	}
-	if err := os.Chdir(dir); err != nil {
-		fmt.Fprintln(stderr, err)
-		return 1
+	fsys := r.FileSystem()
+	prev, err := r.WorkingDir()
+	if err != nil {
+		prev = ""
+	}
+	logical := dir
+	if !filepath.IsAbs(dir) && prev != "" {
+		logical = filepath.Join(prev, dir)
+	}
+	if err := fsys.Chdir(logical); err != nil {
+		if err := fsys.Chdir(dir); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if logical, err = fsys.Getwd(); err != nil {
+			logical = dir
+		}
+	}
+	own := env.runnerEnv()
+	if prev != "" {
+		own.Set("oldpwd", []string{prev})
+	}
+	own.Set("pwd", []string{logical})
+	if show {
+		fmt.Fprintln(stdout, logical)
 	}
 	return 0
 }
 
+// cdTarget finds dir in fsys through $cdpath and reports whether it was
+// found in a non-empty entry.
+func cdTarget(fsys FS, dir string, env *Env) (string, bool) {
+	if filepath.IsAbs(dir) || dir == "." || dir == ".." ||
+		strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return dir, false
+	}
+	cdpath := env.Get("cdpath")
+	if len(cdpath) == 0 {
+		cdpath = []string{""}
+	}
+	for _, entry := range cdpath {
+		path := dir
+		if entry != "" {
+			path = filepath.Join(entry, dir)
+		}
+		if fi, err := fsys.Stat(path); err == nil && fi.IsDir() {
+			return path, entry != ""
+		}
+	}
+	return dir, false
+}
+
 func builtinPWD(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
 	_ = stdin
 	_ = args
-	_ = r
-	cwd, err := os.Getwd()
+	if r == nil {
+		return 1
+	}
+	cwd, err := r.WorkingDir()
 	if err != nil {
 		fmt.Fprintln(stderr, err)
 		return 1
@@ -91,17 +183,13 @@ func builtinExit(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 	_ = stdin
 	_ = stdout
 	_ = stderr
-	code := 0
-	if len(args) > 1 {
-		if n, err := parseInt(args[1]); err == nil {
-			code = n
-		}
-	}
-	if r != nil {
-		r.exitRequested = true
-		r.exitCode = code
+	if r == nil {
+		return Status(args[1:]).Code()
 	}
-	return code
+	status := argStatus(args, r.Env)
+	r.exitRequested = true
+	r.exitStatus = status
+	return r.builtinResult(status)
 }
 
 func builtinExec(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
@@ -111,11 +199,18 @@ func builtinExec(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 		return 0
 	}
 	argv := args[1:]
-	path, ok := resolvePath(argv[0], r.Env, true, stderr)
+	path, ok := resolvePath(argv[0], r, true, stderr)
 	if !ok {
 		return 127
 	}
 	envList := buildExecEnv(r.Env)
+	// The working directory is the runner's, which the process takes on
+	// only now that it is about to become the command.
+	if osfs, ok := r.FileSystem().(*OSFS); ok {
+		if dir, err := osfs.Getwd(); err == nil {
+			_ = os.Chdir(dir)
+		}
+	}
 	if err := syscall.Exec(path, argv, envList); err != nil {
 		fmt.Fprintln(stderr, err)
 		return 127
@@ -141,7 +236,7 @@ func builtinDot(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Run
 	if i+1 < len(args) {
 		rest = args[i+1:]
 	}
-	f, err := os.Open(path)
+	f, err := r.FileSystem().OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
 		fmt.Fprintln(stderr, err)
 		return 1
@@ -157,9 +252,9 @@ func builtinDot(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Run
 	if interactive {
 		r.Interactive = true
 	}
-	ast, err := parse.ParseAll(f)
+	ast, err := parse.ParseAll(r.Input(f.(io.Reader), stderr))
 	if err != nil {
-		fmt.Fprintln(stderr, err)
+		fmt.Fprintln(stderr, sourceError(path, err))
 		r.Interactive = oldInteractive
 		restoreVar(r.Env, "*", oldStar, hadStar)
 		restoreVar(r.Env, "0", oldZero, hadZero)
@@ -167,7 +262,7 @@ func builtinDot(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Run
 	}
 	plan, err := BuildPlan(ast, r.Env)
 	if err != nil {
-		fmt.Fprintln(stderr, err)
+		fmt.Fprintln(stderr, sourceError(path, err))
 		r.Interactive = oldInteractive
 		restoreVar(r.Env, "*", oldStar, hadStar)
 		restoreVar(r.Env, "0", oldZero, hadZero)
@@ -177,7 +272,17 @@ func builtinDot(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Run
 	r.Interactive = oldInteractive
 	restoreVar(r.Env, "*", oldStar, hadStar)
 	restoreVar(r.Env, "0", oldZero, hadZero)
-	return status
+	return r.builtinResult(status)
+}
+
+// sourceError names the file an error was found in, and the line for a
+// syntax error.
+func sourceError(path string, err error) string {
+	var perr *parse.Error
+	if errors.As(err, &perr) && perr.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", path, perr.Line, err)
+	}
+	return fmt.Sprintf("%s: %v", path, err)
 }
 
 func builtinEval(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
@@ -195,7 +300,35 @@ func builtinEval(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 		fmt.Fprintln(stderr, err)
 		return 1
 	}
-	return r.runChain(plan, stdin, stdout, stderr)
+	return r.builtinResult(r.runChain(plan, stdin, stdout, stderr))
+}
+
+// builtinFlag tests the shell flag named by its first argument, or sets it
+// with + and clears it with -.
+func builtinFlag(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	_ = stdout
+	if len(args) < 2 || len(args) > 3 || len(args[1]) != 1 {
+		fmt.Fprintln(stderr, "usage: flag f [+-]")
+		return 1
+	}
+	c := args[1][0]
+	if len(args) == 2 {
+		if r.Flag(c) {
+			return 0
+		}
+		return 1
+	}
+	switch args[2] {
+	case "+":
+		r.SetFlag(c, true)
+	case "-":
+		r.SetFlag(c, false)
+	default:
+		fmt.Fprintln(stderr, "usage: flag f [+-]")
+		return 1
+	}
+	return 0
 }
 
 func builtinWhich(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
@@ -205,7 +338,7 @@ func builtinWhich(stdin io.Reader, stdout, stderr io.Writer, args []string, r *R
 	}
 	ok := true
 	for _, name := range args[1:] {
-		path, found := resolvePath(name, r.Env, true, stderr)
+		path, found := resolvePath(name, r, true, stderr)
 		if !found {
 			ok = false
 			continue
@@ -218,6 +351,87 @@ func builtinWhich(stdin io.Reader, stdout, stderr io.Writer, args []string, r *R
 	return 1
 }
 
+// builtinWhatis prints each name as rc that defines it again: an
+// assignment for a variable, an fn definition for a function, and
+// otherwise "builtin name" or the path of the command. Without arguments
+// it prints every variable and function.
+func builtinWhatis(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	if r == nil || r.Env == nil {
+		return 1
+	}
+	if len(args) < 2 {
+		vars := r.Env.Snapshot()
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(vars[name]) > 0 {
+				fmt.Fprintln(stdout, whatisVar(name, vars[name]))
+			}
+		}
+		fns := r.Env.FuncNames()
+		sort.Strings(fns)
+		for _, name := range fns {
+			if line, ok := whatisFunc(r.Env, name); ok {
+				fmt.Fprintln(stdout, line)
+			}
+		}
+		return 0
+	}
+	status := 0
+	for _, name := range args[1:] {
+		found := false
+		if vals := r.Env.Get(name); len(vals) > 0 {
+			fmt.Fprintln(stdout, whatisVar(name, vals))
+			found = true
+		}
+		if line, ok := whatisFunc(r.Env, name); ok {
+			fmt.Fprintln(stdout, line)
+			found = true
+		}
+		if found {
+			continue
+		}
+		if _, ok := r.Builtins[name]; ok {
+			fmt.Fprintln(stdout, "builtin "+parse.Quote(name))
+			continue
+		}
+		path, ok := resolvePath(name, r, true, stderr)
+		if !ok {
+			status = 1
+			continue
+		}
+		fmt.Fprintln(stdout, parse.Quote(path))
+	}
+	return status
+}
+
+func whatisVar(name string, vals []string) string {
+	if len(vals) == 1 {
+		return parse.Quote(name) + "=" + parse.Quote(vals[0])
+	}
+	quoted := make([]string, len(vals))
+	for i, v := range vals {
+		quoted[i] = parse.Quote(v)
+	}
+	return parse.Quote(name) + "=(" + strings.Join(quoted, " ") + ")"
+}
+
+func whatisFunc(env *Env, name string) (string, bool) {
+	def, ok := env.GetFunc(name)
+	if !ok || def.Body == nil {
+		return "", false
+	}
+	body, err := parse.Format(def.Body)
+	if err != nil {
+		return "", false
+	}
+	return "fn " + parse.Quote(name) + " " + body, true
+}
+
 func builtinNewpgrp(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
 	_ = stdin
 	_ = stdout
@@ -270,21 +484,28 @@ func builtinShift(stdin io.Reader, stdout, stderr io.Writer, args []string, r *R
 func builtinReturn(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
 	_ = stdin
 	_ = stdout
-	_ = stderr
-	code := 0
 	if len(args) > 2 {
 		fmt.Fprintln(stderr, "return: too many arguments")
 		return 1
 	}
-	if len(args) == 2 {
-		if n, err := parseInt(args[1]); err == nil {
-			code = n
-		}
+	if r == nil {
+		return Status(args[1:]).Code()
 	}
-	if r != nil {
-		r.requestReturn(code)
+	status := argStatus(args, r.Env)
+	r.requestReturn(status)
+	return r.builtinResult(status)
+}
+
+// argStatus is the status named by the arguments of exit or return, or
+// the current $status when there are none.
+func argStatus(args []string, env *Env) Status {
+	if len(args) > 1 {
+		return append(Status{}, args[1:]...)
 	}
-	return code
+	if env == nil {
+		return statusTrue
+	}
+	return append(Status{}, env.Get("status")...)
 }
 
 func builtinWait(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
@@ -294,17 +515,17 @@ func builtinWait(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 		return 1
 	}
 	if len(args) == 1 {
-		jobs := r.listJobs()
+		jobs := r.ListJobs()
 		if len(jobs) == 0 {
 			return 0
 		}
-		status := 0
+		var status Status
 		for _, job := range jobs {
-			status = r.waitJob(job)
+			status = r.waitJobStatus(job)
 		}
-		return status
+		return r.builtinResult(status)
 	}
-	status := 0
+	var status Status
 	for _, a := range args[1:] {
 		pid, err := parseInt(a)
 		if err != nil || pid <= 0 {
@@ -316,9 +537,9 @@ func builtinWait(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 			fmt.Fprintf(stderr, "rc: `%s' is not a child\n", a)
 			return 1
 		}
-		status = r.waitJob(job)
+		status = r.waitJobStatus(job)
 	}
-	return status
+	return r.builtinResult(status)
 }
 
 func restoreVar(env *Env, name string, val []string, had bool) {
@@ -332,27 +553,6 @@ func restoreVar(env *Env, name string, val []string, had bool) {
 	}
 }
 
-func buildExecEnv(env *Env) []string {
-	base := map[string]string{}
-	for _, kv := range os.Environ() {
-		parts := strings.SplitN(kv, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		base[parts[0]] = parts[1]
-	}
-	if env != nil {
-		for k, v := range env.Snapshot() {
-			base[k] = strings.Join(v, " ")
-		}
-	}
-	out := make([]string, 0, len(base))
-	for k, v := range base {
-		out = append(out, k+"="+v)
-	}
-	return out
-}
-
 func builtinJobs(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
 	_ = stdin
 	_ = stderr
@@ -360,18 +560,19 @@ func builtinJobs(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 	if r == nil {
 		return 0
 	}
-	jobs := r.listJobs()
+	jobs := r.ListJobs()
 	if len(jobs) == 0 {
 		return 0
 	}
 	_, _ = fmt.Fprint(stdout, formatJobs(jobs))
-	r.mu.Lock()
+	sh := r.shell()
+	sh.mu.Lock()
 	for _, job := range jobs {
 		if job.State == "done" {
 			job.Notified = true
 		}
 	}
-	r.mu.Unlock()
+	sh.mu.Unlock()
 	r.pruneJobs()
 	return 0
 }
@@ -383,6 +584,7 @@ func builtinAPID(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Ru
 	if r == nil || r.Env == nil {
 		return 0
 	}
+	r.pruneAPID()
 	vals := r.Env.Get("apid")
 	if len(vals) == 0 {
 		return 0
@@ -402,16 +604,21 @@ func builtinFG(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runn
 		fmt.Fprintln(stderr, err)
 		return 1
 	}
-	if job.State == "done" {
+	sh := r.shell()
+	sh.mu.Lock()
+	done := job.State == "done"
+	sh.mu.Unlock()
+	if done {
 		r.removeJob(job.ID)
-		return job.Exit
-	}
-	_ = unix.Kill(-job.Pgid, unix.SIGCONT)
-	r.attachForegroundPgid(job.Pgid)
-	exit := r.waitJob(job)
-	r.restoreForeground()
-	r.removeJob(job.ID)
-	return exit
+		return r.builtinResult(job.status)
+	}
+	fmt.Fprintln(stderr, job.Cmd)
+	_ = r.signalJob(job, unix.SIGCONT)
+	status, stopped := r.foregroundJob(job, stderr)
+	if !stopped {
+		r.removeJob(job.ID)
+	}
+	return r.builtinResult(status)
 }
 
 func builtinBG(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
@@ -425,8 +632,8 @@ func builtinBG(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runn
 		fmt.Fprintln(stderr, err)
 		return 1
 	}
-	_ = unix.Kill(-job.Pgid, unix.SIGCONT)
-	job.State = "running"
+	// a job that has finished has nothing to continue
+	_ = r.signalJob(job, unix.SIGCONT)
 	return 0
 }
 
@@ -441,14 +648,17 @@ func resolveJob(args []string, r *Runner) (*Job, error) {
 		return nil, fmt.Errorf("no runner")
 	}
 	var job *Job
-	if len(args) > 1 {
+	switch {
+	case len(args) < 2, args[1] == "%+", args[1] == "%%":
+		job = r.lastJob()
+	case args[1] == "%-":
+		job = r.previousJob()
+	default:
 		id := parseJobID(args[1])
 		if id == 0 {
 			return nil, fmt.Errorf("invalid job id")
 		}
 		job = r.getJob(id)
-	} else {
-		job = r.lastJob()
 	}
 	if job == nil {
 		return nil, fmt.Errorf("no jobs")
@@ -2,8 +2,14 @@ package eval
 
 import (
 	"bytes"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"grc/internal/history"
+	"grc/internal/parse"
 )
 
 func TestBuiltinAPID(t *testing.T) {
@@ -22,3 +28,139 @@ func TestBuiltinAPID(t *testing.T) {
 		t.Fatalf("unexpected output: %q", got)
 	}
 }
+
+func TestBuiltinWhatis(t *testing.T) {
+	env := NewEnv(nil)
+	env.Set("x", []string{"a", "b c", "it's", "*"})
+	env.Set("y", []string{"one"})
+	r := &Runner{Env: env, Builtins: defaultBuiltins()}
+	ast, err := parse.ParseAll(strings.NewReader("fn f { echo $1 | cat }\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	r.RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+
+	var out bytes.Buffer
+	status := builtinWhatis(nil, &out, io.Discard, []string{"whatis", "x", "y", "f", "cd", "nosuch"}, r)
+	if status != 1 {
+		t.Fatalf("expected status 1 for the missing name, got %d", status)
+	}
+	want := "x=(a 'b c' 'it''s' '*')\ny=one\nfn f { echo $1 | cat }\nbuiltin cd\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	restored := NewEnv(nil)
+	ast, err = parse.ParseAll(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err = BuildPlan(ast, restored)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	(&Runner{Env: restored}).RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+	if got := restored.Get("x"); strings.Join(got, "|") != "a|b c|it's|*" {
+		t.Fatalf("unexpected x after reading back: %q", got)
+	}
+	if _, ok := restored.GetFunc("f"); !ok {
+		t.Fatalf("expected f after reading back")
+	}
+}
+
+func TestBuiltinHistory(t *testing.T) {
+	if !haveCmd(t, "echo") {
+		t.Skip("echo not available")
+	}
+	env := NewEnv(nil)
+	env.Set("history", []string{t.TempDir() + "/history"})
+	store := HistoryStore(env)
+	for _, line := range []string{"echo one", "echo two", "echo three"} {
+		if err := store.Add(history.Entry{Line: line}); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+	}
+	r := &Runner{Env: env, Builtins: defaultBuiltins()}
+
+	var out bytes.Buffer
+	if status := builtinHistory(nil, &out, io.Discard, []string{"history", "-g", "t"}, r); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if out.String() != "    2\techo two\n    3\techo three\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	var errOut bytes.Buffer
+	if status := builtinHistory(strings.NewReader(""), &out, &errOut, []string{"history", "-r", "1"}, r); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if out.String() != "one\n" || errOut.String() != "echo one\n" {
+		t.Fatalf("unexpected output: %q, stderr %q", out.String(), errOut.String())
+	}
+	if status := builtinHistory(nil, &out, io.Discard, []string{"history", "-r", "9"}, r); status == 0 {
+		t.Fatalf("expected failure for a missing entry")
+	}
+}
+
+func TestBuiltinCdPath(t *testing.T) {
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	defer os.Chdir(old)
+	base := t.TempDir()
+	for _, dir := range []string{"projects/grc/internal", "work"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(base, "projects"), filepath.Join(base, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	env := NewEnv(nil)
+	env.Set("cdpath", []string{"", filepath.Join(base, "projects")})
+	r := &Runner{Env: env}
+	cd := func(args ...string) string {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		if status := builtinCD(nil, &out, &errOut, append([]string{"cd"}, args...), r); status != 0 {
+			t.Fatalf("cd %q failed: %s", args, errOut.String())
+		}
+		return out.String()
+	}
+
+	cd(filepath.Join(base, "work"))
+	if out := cd("grc"); out != filepath.Join(base, "projects", "grc")+"\n" {
+		t.Fatalf("expected cd to print the $cdpath match, got %q", out)
+	}
+	if out := cd("internal"); out != "" {
+		t.Fatalf("a match in the current directory should not print, got %q", out)
+	}
+	if out := cd("-"); out != filepath.Join(base, "projects", "grc")+"\n" {
+		t.Fatalf("unexpected cd - output: %q", out)
+	}
+	if got := env.Get("oldpwd"); len(got) != 1 || got[0] != filepath.Join(base, "projects", "grc", "internal") {
+		t.Fatalf("unexpected $oldpwd: %q", got)
+	}
+
+	cd(filepath.Join(base, "link", "grc"))
+	cd("..")
+	want := filepath.Join(base, "link")
+	if got := env.Get("pwd"); len(got) != 1 || got[0] != want {
+		t.Fatalf("expected the logical $pwd %s, got %q", want, got)
+	}
+	if got := env.Get("PWD"); len(got) != 1 || got[0] != want {
+		t.Fatalf("expected $PWD to follow $pwd, got %q", got)
+	}
+	var out bytes.Buffer
+	builtinPWD(nil, &out, io.Discard, nil, r)
+	if out.String() != want+"\n" {
+		t.Fatalf("unexpected pwd output: %q", out.String())
+	}
+}
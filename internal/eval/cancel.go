@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultKillDelay is how long a cancelled command has to exit after
+// SIGTERM when Runner.KillDelay is zero.
+const defaultKillDelay = 2 * time.Second
+
+// statusInterrupted is the status of a plan whose context was cancelled.
+var statusInterrupted = Status{"interrupted"}
+
+// RunPlanContext runs p as RunPlan does, until ctx is done. From then on
+// no further command starts, running external commands get SIGTERM and,
+// KillDelay later, SIGKILL, and the status is "interrupted". Background
+// jobs are left running. Commands started while ctx can be cancelled get
+// process groups of their own, so that the signals reach their children.
+// The plan runs on a child runner holding ctx; an exit request and the x
+// flag carry back to r.
+func (r *Runner) RunPlanContext(ctx context.Context, p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Result {
+	r.setup()
+	run := r.childRunner(ctx)
+	res := run.runPlan(p, stdin, stdout, stderr)
+	if run.exitRequested {
+		r.exitRequested, r.exitStatus = true, run.exitStatus
+	}
+	r.Trace = run.Trace
+	return res
+}
+
+// Context returns the context of the running plan, for builtins that
+// block.
+func (r *Runner) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// interrupted reports whether the context of the running plan is done.
+func (r *Runner) interrupted() bool {
+	return r.Context().Err() != nil
+}
+
+// cancellable reports whether the running plan can be cancelled.
+func (r *Runner) cancellable() bool {
+	return r.Context().Done() != nil
+}
+
+// killOnCancel arms the process group pgid, and those of procs without a
+// pid, to be sent SIGTERM when the running plan is cancelled, and SIGKILL
+// if they have not been reaped KillDelay later. The returned function
+// disarms it.
+func (r *Runner) killOnCancel(pgid int, procs []Process) (stop func()) {
+	if (pgid <= 0 && len(procs) == 0) || !r.cancellable() {
+		return func() {}
+	}
+	delay := r.KillDelay
+	if delay <= 0 {
+		delay = defaultKillDelay
+	}
+	var (
+		mu    sync.Mutex
+		done  bool
+		timer *time.Timer
+	)
+	stopCancel := context.AfterFunc(r.Context(), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		_ = signalGroup(pgid, procs, unix.SIGTERM)
+		_ = signalGroup(pgid, procs, unix.SIGCONT)
+		timer = time.AfterFunc(delay, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if !done {
+				_ = signalGroup(pgid, procs, unix.SIGKILL)
+			}
+		})
+	})
+	return func() {
+		stopCancel()
+		mu.Lock()
+		done = true
+		if timer != nil {
+			timer.Stop()
+		}
+		mu.Unlock()
+	}
+}
+
+// closeOnCancel closes files when the running plan is cancelled, so that
+// pipeline stages inside the shell blocked on them see EOF or a broken
+// pipe. The returned function disarms it.
+func (r *Runner) closeOnCancel(files []*os.File) (stop func()) {
+	if len(files) == 0 || !r.cancellable() {
+		return func() {}
+	}
+	stopCancel := context.AfterFunc(r.Context(), func() { closeFiles(files) })
+	return func() { stopCancel() }
+}
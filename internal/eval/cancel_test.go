@@ -0,0 +1,104 @@
+package eval
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"grc/internal/parse"
+)
+
+func runContextInput(t *testing.T, r *Runner, input string, timeout time.Duration) (Result, time.Duration) {
+	t.Helper()
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	res := r.RunPlanContext(ctx, plan, strings.NewReader(""), io.Discard, io.Discard)
+	return res, time.Since(start)
+}
+
+func TestRunPlanContextLoops(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	res, took := runContextInput(t, r, "while(true) x=1\nafter=1\n", 50*time.Millisecond)
+	if res.List.String() != "interrupted" || took > 2*time.Second {
+		t.Fatalf("unexpected result: %q after %v", res.List, took)
+	}
+	if len(r.Env.Get("after")) != 0 {
+		t.Fatalf("a command ran after the cancel")
+	}
+	if s := r.Env.Get("status"); len(s) != 1 || s[0] != "interrupted" {
+		t.Fatalf("unexpected $status: %q", s)
+	}
+
+	res, _ = runContextInput(t, r, "fn f { for(i in 1 2 3) while(true) x=$i }\nf\n", 50*time.Millisecond)
+	if res.List.String() != "interrupted" {
+		t.Fatalf("unexpected function status: %q", res.List)
+	}
+}
+
+func TestRunPlanContextKills(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "sleep") {
+		t.Skip("sh or sleep not available")
+	}
+	cases := []string{
+		"sleep 10\n",
+		"sleep 10 | sleep 10\n",
+		"fn f { sleep 10 }\nf | f\n",
+		"x=`{sleep 10}\n",
+		"@{sleep 10}\n",
+	}
+	for _, input := range cases {
+		r := &Runner{Env: NewEnv(nil)}
+		res, took := runContextInput(t, r, input, 100*time.Millisecond)
+		if !strings.Contains(res.List.String(), "interrupted") || took > 2*time.Second {
+			t.Fatalf("%q: unexpected result %q after %v", input, res.List, took)
+		}
+	}
+
+	// A command that ignores SIGTERM is killed after KillDelay.
+	r := &Runner{Env: NewEnv(nil), KillDelay: 200 * time.Millisecond}
+	res, took := runContextInput(t, r, "sh -c 'trap \"\" TERM; sleep 10 & wait'\n", 100*time.Millisecond)
+	if res.List.String() != "interrupted" || took < 300*time.Millisecond || took > 2*time.Second {
+		t.Fatalf("unexpected result %q after %v", res.List, took)
+	}
+}
+
+func TestRunPlanContextBuiltin(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	r.SetBuiltin("block", func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+		<-r.Context().Done()
+		return 0
+	})
+	r.SetBuiltin("slurp", func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+		_, _ = io.ReadAll(stdin)
+		return 0
+	})
+	res, took := runContextInput(t, r, "block | slurp\n", 50*time.Millisecond)
+	if res.List.String() != "interrupted" || took > 2*time.Second {
+		t.Fatalf("unexpected result %q after %v", res.List, took)
+	}
+}
+
+func TestRunPlanContextLeavesRunner(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	runContextInput(t, r, "x=1\nexit 4\n", time.Second)
+	if !r.ExitRequested() || r.ExitCode() != 4 {
+		t.Fatalf("expected the exit request to reach the runner")
+	}
+	if got := r.Env.Get("x"); len(got) != 1 || got[0] != "1" {
+		t.Fatalf("unexpected x: %q", got)
+	}
+	if r.Context() != context.Background() {
+		t.Fatalf("expected the runner's own context to be left alone")
+	}
+}
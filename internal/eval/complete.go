@@ -0,0 +1,95 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"grc/internal/parse"
+)
+
+// completerPrefix names the function that completes the arguments of a
+// command when the complete table has no entry for it.
+const completerPrefix = "complete_"
+
+// Complete runs the completion function registered for words[0], or the
+// function complete_<command>, for the word at the 1-based index in words.
+// The function is called with the index followed by the words; every line
+// it prints that extends the current word is a candidate. ok is false when
+// the command has no completion function.
+func (r *Runner) Complete(words []string, index int) (candidates []string, ok bool) {
+	if r == nil || r.Env == nil || len(words) == 0 || index < 1 || index > len(words) {
+		return nil, false
+	}
+	fn, ok := r.completerFor(words[0])
+	if !ok {
+		return nil, false
+	}
+	var out bytes.Buffer
+	saved := r.Env.Get("status")
+	args := append([]string{strconv.Itoa(index)}, words...)
+	r.CallFunc(fn, args, strings.NewReader(""), &out, io.Discard)
+	r.Env.Set("status", saved)
+
+	prefix := words[index-1]
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line == "" || seen[line] || !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		seen[line] = true
+		candidates = append(candidates, line)
+	}
+	return candidates, true
+}
+
+func (r *Runner) completerFor(cmd string) (string, bool) {
+	if fn, ok := r.completers[cmd]; ok {
+		if _, defined := r.Env.GetFunc(fn); defined {
+			return fn, true
+		}
+	}
+	if _, defined := r.Env.GetFunc(completerPrefix + cmd); defined {
+		return completerPrefix + cmd, true
+	}
+	return "", false
+}
+
+// builtinComplete maintains the table of completion functions:
+//
+//	complete            list the table
+//	complete cmd fn     complete the arguments of cmd with fn
+//	complete -r cmd     remove the entry for cmd
+func builtinComplete(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	if r == nil {
+		return 1
+	}
+	sh := r.shell()
+	switch {
+	case len(args) == 1:
+		cmds := make([]string, 0, len(sh.completers))
+		for cmd := range sh.completers {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		for _, cmd := range cmds {
+			fmt.Fprintf(stdout, "complete %s %s\n", parse.Quote(cmd), parse.Quote(sh.completers[cmd]))
+		}
+		return 0
+	case len(args) == 3 && args[1] == "-r":
+		delete(sh.completers, args[2])
+		return 0
+	case len(args) == 3:
+		if sh.completers == nil {
+			sh.completers = make(map[string]string)
+		}
+		sh.completers[args[1]] = args[2]
+		return 0
+	}
+	fmt.Fprintln(stderr, "usage: complete [cmd fn | -r cmd]")
+	return 1
+}
@@ -0,0 +1,71 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func newCompleteRunner(t *testing.T, input string) *Runner {
+	t.Helper()
+	r := &Runner{Env: NewEnv(nil)}
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	r.RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+	return r
+}
+
+func TestCompleteFunction(t *testing.T) {
+	if !haveCmd(t, "echo") {
+		t.Skip("echo not available")
+	}
+	r := newCompleteRunner(t, "fn complete_git { if(~ $1 2) { echo add; echo am; echo commit; echo add } }\n")
+	r.Env.Set("status", []string{"7"})
+	got, ok := r.Complete([]string{"git", "a"}, 2)
+	if !ok {
+		t.Fatalf("expected complete_git to be used")
+	}
+	if strings.Join(got, " ") != "add am" {
+		t.Fatalf("unexpected candidates: %q", got)
+	}
+	if s := r.Env.Get("status"); len(s) != 1 || s[0] != "7" {
+		t.Fatalf("completion changed $status: %q", s)
+	}
+	got, ok = r.Complete([]string{"git", "add", ""}, 3)
+	if !ok || len(got) != 0 {
+		t.Fatalf("unexpected candidates for word 3: %q, %v", got, ok)
+	}
+	if _, ok := r.Complete([]string{"ls", ""}, 2); ok {
+		t.Fatalf("ls has no completion function")
+	}
+}
+
+func TestCompleteTable(t *testing.T) {
+	if !haveCmd(t, "echo") {
+		t.Skip("echo not available")
+	}
+	r := newCompleteRunner(t, "fn tool_words { echo $1 $#* }\ncomplete tool tool_words\n")
+	got, ok := r.Complete([]string{"tool", "x", ""}, 3)
+	if !ok || strings.Join(got, " ") != "3 4" {
+		t.Fatalf("unexpected candidates: %q, %v", got, ok)
+	}
+
+	var out bytes.Buffer
+	builtinComplete(nil, &out, io.Discard, []string{"complete"}, r)
+	if out.String() != "complete tool tool_words\n" {
+		t.Fatalf("unexpected table: %q", out.String())
+	}
+	builtinComplete(nil, &out, io.Discard, []string{"complete", "-r", "tool"}, r)
+	if _, ok := r.Complete([]string{"tool", ""}, 2); ok {
+		t.Fatalf("expected the entry to be removed")
+	}
+}
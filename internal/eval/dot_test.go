@@ -2,6 +2,7 @@ package eval
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -39,3 +40,18 @@ func TestDotBuiltin(t *testing.T) {
 		t.Fatalf("unexpected stdout: %q", out.String())
 	}
 }
+
+func TestDotSyntaxErrorLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.rc")
+	if err := os.WriteFile(path, []byte("x=1\necho )\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	var errOut bytes.Buffer
+	if status := builtinDot(nil, io.Discard, &errOut, []string{".", path}, r); status != 1 {
+		t.Fatalf("expected status 1, got %d", status)
+	}
+	if want := path + ":2: syntax error\n"; errOut.String() != want {
+		t.Fatalf("unexpected stderr: %q", errOut.String())
+	}
+}
@@ -18,8 +18,16 @@ func dumpPlan(b *strings.Builder, p *ExecPlan, indent int) {
 	}
 	pad := strings.Repeat(" ", indent)
 	fmt.Fprintf(b, "%s- %s\n", pad, planLine(p))
+	if p.Block != nil {
+		fmt.Fprintf(b, "%s  BLOCK->\n", pad)
+		dumpPlan(b, p.Block, indent+4)
+	}
 	if p.PipeTo != nil {
-		fmt.Fprintf(b, "%s  PIPE->\n", pad)
+		if left, right := pipeFDs(p); left != 1 || right != 0 {
+			fmt.Fprintf(b, "%s  PIPE[%d=%d]->\n", pad, left, right)
+		} else {
+			fmt.Fprintf(b, "%s  PIPE->\n", pad)
+		}
 		dumpPlan(b, p.PipeTo, indent+4)
 	}
 	if p.IfOK != nil {
@@ -92,6 +100,8 @@ func planKindName(k PlanKind) string {
 		return "SUBSHELL"
 	case PlanTwiddle:
 		return "MATCH"
+	case PlanBlock:
+		return "BLOCK"
 	default:
 		return "UNKNOWN"
 	}
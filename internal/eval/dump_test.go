@@ -24,3 +24,18 @@ func TestDumpPlanContainsLinks(t *testing.T) {
 		t.Fatalf("expected NEXT in dump, got %q", dump)
 	}
 }
+
+func TestDumpPlanPipeFDs(t *testing.T) {
+	ast, err := parse.ParseAll(strings.NewReader("a |[2] b\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, NewEnv(nil))
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	dump := DumpPlan(plan)
+	if !strings.Contains(dump, "PIPE[2=0]") {
+		t.Fatalf("expected PIPE[2=0] in dump, got %q", dump)
+	}
+}
@@ -11,6 +11,7 @@ type Env struct {
 	parent *Env
 	vars   map[string][]string
 	funcs  map[string]FuncDef
+	runner *Runner // the runner of the plan using the env, for backquotes
 }
 
 // FuncDef stores a function definition.
@@ -71,6 +72,26 @@ func (e *Env) Snapshot() map[string][]string {
 	return out
 }
 
+// Root returns the outermost environment of the chain.
+func (e *Env) Root() *Env {
+	for e != nil && e.parent != nil {
+		e = e.parent
+	}
+	return e
+}
+
+// runnerEnv returns the outermost environment of the runner whose plan
+// uses e: the shell's top level, or the variables a stage runner was
+// given. What the runner keeps for itself, such as $pwd, is set there.
+func (e *Env) runnerEnv() *Env {
+	for cur := e; cur != nil; cur = cur.parent {
+		if cur.runner != nil {
+			return cur
+		}
+	}
+	return e.Root()
+}
+
 // FuncNames returns function names visible from this env chain.
 func (e *Env) FuncNames() []string {
 	seen := make(map[string]struct{})
@@ -96,6 +117,9 @@ func (e *Env) Set(name string, vals []string) {
 		e.vars = make(map[string][]string)
 	}
 	e.vars[name] = vals
+	if mirror, mvals, ok := mirrorOf(name, vals); ok {
+		e.vars[mirror] = mvals
+	}
 }
 
 // SetPositional sets $* and numeric positional parameters.
@@ -128,6 +152,9 @@ func (e *Env) Unset(name string) {
 		return
 	}
 	delete(e.vars, name)
+	if mirror, _, ok := mirrorOf(name, nil); ok {
+		delete(e.vars, mirror)
+	}
 }
 
 // SetFunc defines a function in the current environment.
@@ -170,15 +197,33 @@ func (e *Env) SetStatus(code int) {
 	e.Set("status", []string{strconv.Itoa(code)})
 }
 
-// GetStatus returns the numeric status value or 0 if unset/invalid.
+// SetStatusList sets the status variable to an rc status list.
+func (e *Env) SetStatusList(s Status) {
+	e.Set("status", append([]string{}, s...))
+}
+
+// GetStatus returns the status as a numeric exit code, 0 if unset.
 func (e *Env) GetStatus() int {
-	vals := e.Get("status")
-	if len(vals) == 0 {
-		return 0
-	}
-	n, err := strconv.Atoi(vals[0])
-	if err != nil {
-		return 0
+	return Status(e.Get("status")).Code()
+}
+
+// nestedRunner returns a runner for a command, such as a backquote, that
+// runs inside the plan using e. It shares the context, executor, kill
+// delay and trace of the runner of that plan, and starts in its working
+// directory.
+func (e *Env) nestedRunner() *Runner {
+	r := &Runner{Env: e, nested: true}
+	for cur := e; cur != nil; cur = cur.parent {
+		if outer := cur.runner; outer != nil {
+			r.ctx = outer.ctx
+			r.Executor = outer.Executor
+			r.KillDelay = outer.KillDelay
+			r.FS = forkFS(outer.FileSystem())
+			r.TraceJSON = outer.TraceJSON
+			r.tracer = outer.tracer
+			r.traceDepth = outer.traceDepth
+			break
+		}
 	}
-	return n
+	return r
 }
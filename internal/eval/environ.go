@@ -0,0 +1,183 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"grc/internal/parse"
+)
+
+// The environment uses rc's encoding: the elements of a list variable are
+// separated by \x01, and a function travels as an fn#name entry whose value
+// is its formatted body.
+const (
+	envListSep  = "\x01"
+	envFnPrefix = "fn#"
+)
+
+// noExport names variables that describe one shell process and are not
+// passed on to children. Mirrored rc variables travel as their environment
+// counterpart instead.
+var noExport = map[string]bool{
+	"*":      true,
+	"0":      true,
+	"apid":   true,
+	"pid":    true,
+	"status": true,
+}
+
+// envMirror pairs rc variables with the environment variables that carry
+// them to other programs. Assigning either side updates the other; list
+// values are joined with colons.
+type envMirror struct {
+	rc   string
+	env  string
+	list bool
+}
+
+var envMirrors = []envMirror{
+	{rc: "path", env: "PATH", list: true},
+	{rc: "home", env: "HOME"},
+	{rc: "cdpath", env: "CDPATH", list: true},
+	{rc: "pwd", env: "PWD"},
+	{rc: "oldpwd", env: "OLDPWD"},
+}
+
+// mirrorOf returns the variable kept in step with name and its value for
+// vals.
+func mirrorOf(name string, vals []string) (string, []string, bool) {
+	for _, m := range envMirrors {
+		switch name {
+		case m.rc:
+			if len(vals) == 0 {
+				return m.env, nil, true
+			}
+			return m.env, []string{strings.Join(vals, ":")}, true
+		case m.env:
+			joined := strings.Join(vals, ":")
+			if joined == "" {
+				return m.rc, nil, true
+			}
+			if !m.list {
+				return m.rc, []string{joined}, true
+			}
+			return m.rc, strings.Split(joined, ":"), true
+		}
+	}
+	return "", nil, false
+}
+
+func exported(name string) bool {
+	if noExport[name] || name == "" || strings.Contains(name, "=") {
+		return false
+	}
+	for _, m := range envMirrors {
+		if name == m.rc {
+			return false
+		}
+	}
+	return strings.Trim(name, "0123456789") != ""
+}
+
+// buildExecEnv returns the environment for a child process: the shell's own
+// environment overlaid with every variable and function visible from env.
+func buildExecEnv(env *Env) []string {
+	base := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		base[parts[0]] = parts[1]
+	}
+	if env != nil {
+		for k, v := range env.Snapshot() {
+			if !exported(k) {
+				continue
+			}
+			if len(v) == 0 {
+				delete(base, k)
+				continue
+			}
+			base[k] = strings.Join(v, envListSep)
+		}
+		for _, name := range env.FuncNames() {
+			def, ok := env.GetFunc(name)
+			if !ok || def.Body == nil || strings.Contains(name, "=") {
+				continue
+			}
+			body, err := parse.Format(def.Body)
+			if err != nil {
+				continue
+			}
+			base[envFnPrefix+name] = body
+		}
+	}
+	out := make([]string, 0, len(base))
+	for k, v := range base {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ImportEnv defines the variables and functions encoded in environ, which
+// holds name=value entries in the form produced for child processes.
+// Entries that cannot be decoded are skipped and reported in the error.
+func ImportEnv(env *Env, environ []string) error {
+	if env == nil {
+		return nil
+	}
+	var errs []error
+	for _, kv := range environ {
+		name, val, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			continue
+		}
+		if fn, ok := strings.CutPrefix(name, envFnPrefix); ok {
+			if err := importFunc(env, fn, val); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			continue
+		}
+		env.Set(name, strings.Split(val, envListSep))
+	}
+	return errors.Join(errs...)
+}
+
+func importFunc(env *Env, name, body string) error {
+	if name == "" {
+		return errors.New("missing function name")
+	}
+	ast, err := parse.ParseAll(strings.NewReader("fn " + parse.Quote(name) + " " + body + "\n"))
+	if err != nil {
+		return err
+	}
+	def := findFnDef(ast)
+	if def == nil || def.Right == nil {
+		return errors.New("not a function body")
+	}
+	env.SetFunc(name, def.Right)
+	return nil
+}
+
+func findFnDef(n *parse.Node) *parse.Node {
+	for n != nil {
+		switch n.Kind {
+		case parse.KFnDef:
+			return n
+		case parse.KSeq:
+			if n.Left != nil {
+				n = n.Left
+				continue
+			}
+			n = n.Right
+			continue
+		}
+		return nil
+	}
+	return nil
+}
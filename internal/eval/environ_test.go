@@ -0,0 +1,187 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func envEntry(environ []string, name string) (string, bool) {
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func TestExecEnvEncodesLists(t *testing.T) {
+	env := NewEnv(nil)
+	env.Set("x", []string{"a", "b c", ""})
+	env.Set("status", []string{"1"})
+	env.Set("1", []string{"pos"})
+	environ := buildExecEnv(env)
+	if v, ok := envEntry(environ, "x"); !ok || v != "a\x01b c\x01" {
+		t.Fatalf("unexpected x entry: %q", v)
+	}
+	for _, name := range []string{"status", "1"} {
+		if _, ok := envEntry(environ, name); ok {
+			t.Fatalf("%s should not be exported", name)
+		}
+	}
+}
+
+func TestExecEnvEmptyListUnsets(t *testing.T) {
+	t.Setenv("GRC_TEST_VAR", "inherited")
+	env := NewEnv(nil)
+	env.Set("GRC_TEST_VAR", nil)
+	if _, ok := envEntry(buildExecEnv(env), "GRC_TEST_VAR"); ok {
+		t.Fatalf("empty list should remove the inherited entry")
+	}
+}
+
+func TestEnvRoundTrip(t *testing.T) {
+	src := NewEnv(nil)
+	src.Set("x", []string{"a", "b c"})
+	ast, err := parse.ParseAll(strings.NewReader("fn greet { for(i in $*) echo hi 'a  b' $i | cat }\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, src)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	(&Runner{Env: src}).RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+	environ := buildExecEnv(src)
+	if v, ok := envEntry(environ, "fn#greet"); !ok || !strings.HasPrefix(v, "{") {
+		t.Fatalf("unexpected fn#greet entry: %q", v)
+	}
+
+	dst := NewEnv(nil)
+	if err := ImportEnv(dst, environ); err != nil {
+		t.Fatalf("ImportEnv returned error: %v", err)
+	}
+	if got := dst.Get("x"); len(got) != 2 || got[0] != "a" || got[1] != "b c" {
+		t.Fatalf("unexpected x after import: %q", got)
+	}
+	if !haveCmd(t, "cat") {
+		t.Skip("cat not available")
+	}
+	ast, err = parse.ParseAll(strings.NewReader("greet one\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err = BuildPlan(ast, dst)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	(&Runner{Env: dst}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if out.String() != "hi a  b one\n" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestImportEnvBadFunction(t *testing.T) {
+	env := NewEnv(nil)
+	err := ImportEnv(env, []string{"fn#bad={ echo", "ok=1"})
+	if err == nil || !strings.Contains(err.Error(), "fn#bad") {
+		t.Fatalf("expected error naming fn#bad, got %v", err)
+	}
+	if got := env.Get("ok"); len(got) != 1 || got[0] != "1" {
+		t.Fatalf("unexpected ok after import: %q", got)
+	}
+	if _, ok := env.GetFunc("bad"); ok {
+		t.Fatalf("bad function should not be defined")
+	}
+}
+
+func TestExternalSeesVariables(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	env := NewEnv(nil)
+	ast, err := parse.ParseAll(strings.NewReader("y=(1 2)\nx=a sh -c 'printf %s \"$x$y\"'\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	(&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if out.String() != "a1\x012" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestMirroredVariables(t *testing.T) {
+	env := NewEnv(nil)
+	env.Set("path", []string{"/opt/bin", "/usr/bin"})
+	if got := env.Get("PATH"); len(got) != 1 || got[0] != "/opt/bin:/usr/bin" {
+		t.Fatalf("unexpected PATH: %q", got)
+	}
+	env.Set("CDPATH", []string{"/a::/b"})
+	if got := env.Get("cdpath"); strings.Join(got, ",") != "/a,,/b" {
+		t.Fatalf("unexpected cdpath: %q", got)
+	}
+	env.Set("HOME", []string{"/home/x:y"})
+	if got := env.Get("home"); len(got) != 1 || got[0] != "/home/x:y" {
+		t.Fatalf("unexpected home: %q", got)
+	}
+	env.Set("cdpath", nil)
+	if got := env.Get("CDPATH"); len(got) != 0 {
+		t.Fatalf("unexpected CDPATH: %q", got)
+	}
+
+	environ := buildExecEnv(env)
+	if v, ok := envEntry(environ, "PATH"); !ok || v != "/opt/bin:/usr/bin" {
+		t.Fatalf("unexpected exported PATH: %q", v)
+	}
+	if v, ok := envEntry(environ, "HOME"); !ok || v != "/home/x:y" {
+		t.Fatalf("unexpected exported HOME: %q", v)
+	}
+	for _, name := range []string{"path", "home", "cdpath", "CDPATH"} {
+		if _, ok := envEntry(environ, name); ok {
+			t.Fatalf("%s should not be exported", name)
+		}
+	}
+
+	imported := NewEnv(nil)
+	if err := ImportEnv(imported, []string{"PATH=/x:/y", "HOME=/h"}); err != nil {
+		t.Fatalf("ImportEnv returned error: %v", err)
+	}
+	if got := imported.Get("path"); strings.Join(got, " ") != "/x /y" {
+		t.Fatalf("unexpected imported path: %q", got)
+	}
+	if got := imported.Get("home"); len(got) != 1 || got[0] != "/h" {
+		t.Fatalf("unexpected imported home: %q", got)
+	}
+}
+
+func TestChildSeesAssignedPath(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	env := NewEnv(nil)
+	env.Set("path", pathList(nil))
+	input := "path=(" + dir + " $path)\nsh -c 'printf %s \"$PATH\"'\n"
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	(&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if !strings.HasPrefix(out.String(), dir+":") {
+		t.Fatalf("unexpected PATH in child: %q", out.String())
+	}
+}
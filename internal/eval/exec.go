@@ -26,6 +26,8 @@ type ExecPlan struct {
 	Call       *parse.Node
 	Redirs     []RedirPlan
 	PipeTo     *ExecPlan
+	PipeFd     int
+	PipeToFd   int
 	Next       *ExecPlan
 	IfOK       *ExecPlan
 	IfFail     *ExecPlan
@@ -47,6 +49,8 @@ type ExecPlan struct {
 	SubBody    *parse.Node
 	MatchSubj  *parse.Node
 	MatchPats  *parse.Node
+	Block      *ExecPlan // the commands of a brace block with redirections
+	Pos        parse.Pos // first token of the node in its source
 }
 
 // AssignPrefix holds a temporary assignment for a command invocation.
@@ -71,10 +75,19 @@ const (
 	PlanSubshell
 	PlanTwiddle
 	PlanFnRm
+	PlanBlock
 )
 
 // BuildPlan converts an AST into an execution plan.
 func BuildPlan(ast *parse.Node, env *Env) (*ExecPlan, error) {
+	plan, err := buildPlan(ast, env)
+	if plan != nil && plan.Pos.Line == 0 {
+		plan.Pos = firstPos(ast)
+	}
+	return plan, err
+}
+
+func buildPlan(ast *parse.Node, env *Env) (*ExecPlan, error) {
 	if ast == nil {
 		return nil, nil
 	}
@@ -106,7 +119,13 @@ func BuildPlan(ast *parse.Node, env *Env) (*ExecPlan, error) {
 		if left == nil {
 			return right, nil
 		}
-		left.PipeTo = right
+		last := left
+		for last.PipeTo != nil {
+			last = last.PipeTo
+		}
+		last.PipeTo = right
+		last.PipeFd = ast.I1
+		last.PipeToFd = ast.I2
 		return left, nil
 	case parse.KBg:
 		plan, err := BuildPlan(ast.Left, env)
@@ -149,10 +168,13 @@ func BuildPlan(ast *parse.Node, env *Env) (*ExecPlan, error) {
 		if ast.Right == nil {
 			return BuildPlan(ast.Left, env)
 		}
-		plan, err := BuildPlan(ast.Left, env)
+		body, err := BuildPlan(ast.Left, env)
 		if err != nil {
 			return nil, err
 		}
+		// The block's redirections are opened once around all of its
+		// commands, not added to the first of them.
+		plan := &ExecPlan{Kind: PlanBlock, Block: body, Pos: ast.Pos}
 		if err := applyRedirsFromNode(plan, ast.Right, env); err != nil {
 			return nil, err
 		}
@@ -202,6 +224,16 @@ func BuildPlan(ast *parse.Node, env *Env) (*ExecPlan, error) {
 		}
 		plan.Redirs = append(plan.Redirs, RedirPlan{Op: ast.Tok, Target: target, Fd: ast.I1})
 		return plan, nil
+	case parse.KDup:
+		plan, err := BuildPlan(ast.Left, env)
+		if err != nil {
+			return nil, err
+		}
+		if plan == nil {
+			plan = &ExecPlan{}
+		}
+		plan.Redirs = append(plan.Redirs, RedirPlan{Op: "dup", Fd: ast.I1, DupTo: ast.I2, Close: ast.I2 < 0})
+		return plan, nil
 	case parse.KNmpipe:
 		plan, err := BuildPlan(ast.Left, env)
 		if err != nil {
@@ -333,11 +365,9 @@ func applyRedirsFromNode(plan *ExecPlan, n *parse.Node, env *Env) error {
 			if child == nil {
 				continue
 			}
-			target, err := ExpandWord(child.Right, env)
-			if err != nil {
+			if err := applyRedirsFromNode(plan, child, env); err != nil {
 				return err
 			}
-			plan.Redirs = append(plan.Redirs, RedirPlan{Op: child.Tok, Target: target, Fd: child.I1})
 		}
 		return nil
 	}
@@ -439,6 +469,23 @@ func splitPre(n *parse.Node) ([]AssignPrefix, []*parse.Node, *parse.Node) {
 	return prefixes, redirs, cur
 }
 
+// pipeFDs returns the descriptor of p written into the pipe and the
+// descriptor of p.PipeTo that reads from it. A negative descriptor, such
+// as fdUnset, stands for the default 1 or 0.
+func pipeFDs(p *ExecPlan) (int, int) {
+	left, right := 1, 0
+	if p == nil {
+		return left, right
+	}
+	if p.PipeFd >= 0 {
+		left = p.PipeFd
+	}
+	if p.PipeToFd >= 0 {
+		right = p.PipeToFd
+	}
+	return left, right
+}
+
 // Tail returns the last plan in the Next chain.
 func Tail(p *ExecPlan) *ExecPlan {
 	if p == nil {
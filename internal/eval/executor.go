@@ -0,0 +1,152 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotFound is returned by an Executor for a program it cannot find.
+var ErrNotFound = errors.New("not found")
+
+// Executor starts the external commands of a Runner. It is the one place
+// programs are run, so a replacement can log commands, refuse them, or
+// fake them in tests. A Runner with no Executor uses OSExecutor.
+type Executor interface {
+	Start(c *Command) (Process, error)
+}
+
+// Command is an external command ready to start, with its redirections
+// applied.
+type Command struct {
+	Argv    []string   // arguments; Argv[0] is the name as written
+	Path    []string   // directories to search for Argv[0], from $path
+	Env     []string   // environment, as name=value
+	Dir     string     // working directory, from the runner's FS
+	Stdin   io.Reader  // descriptor 0
+	Stdout  io.Writer  // descriptor 1
+	Stderr  io.Writer  // descriptor 2
+	Files   []*os.File // descriptors 3 and up; nil entries are closed
+	Setpgid bool       // put the process in the process group Pgid
+	Pgid    int        // process group to join; 0 starts a new one
+}
+
+// Process is a started external command.
+type Process interface {
+	// Pid returns the process id, or 0 for a process that is not a child
+	// of the shell, such as a fake one. The runner puts processes with a
+	// pid in process groups, signals the groups and reaps them.
+	Pid() int
+	// Wait blocks until the process has exited and returns its status.
+	// The runner calls it once, after the process has been reaped.
+	Wait() Status
+	// Signal sends sig to the process. The runner uses it for processes
+	// without a pid, which no process group signal reaches.
+	Signal(sig syscall.Signal) error
+}
+
+// OSExecutor runs commands as child processes.
+type OSExecutor struct{}
+
+// Start looks Argv[0] up in Path, from Dir, and starts it.
+func (OSExecutor) Start(c *Command) (Process, error) {
+	path, ok := lookPath(NewOSFS(c.Dir), c.Argv[0], c.Path)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if c.Dir != "" && !filepath.IsAbs(path) {
+		path = c.Dir + "/" + path
+	}
+	cmd := exec.Command(path, c.Argv[1:]...)
+	cmd.Args = c.Argv
+	cmd.Env = c.Env
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	cmd.ExtraFiles = c.Files
+	if c.Setpgid {
+		cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true, Pgid: c.Pgid}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return osProcess{cmd}, nil
+}
+
+type osProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p osProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p osProcess) Wait() Status {
+	return errStatus(p.cmd.Wait())
+}
+
+func (p osProcess) Signal(sig syscall.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (r *Runner) executor() Executor {
+	if r.Executor != nil {
+		return r.Executor
+	}
+	return OSExecutor{}
+}
+
+// buildCommand describes argv, with the redirections of p applied, for
+// the executor. The returned cleanup releases the descriptors the shell
+// keeps for the command once it has exited.
+func buildCommand(argv []string, p *ExecPlan, r *Runner, env *Env, stdin io.Reader, stdout, stderr io.Writer, base fdTable) (*Command, func(), error) {
+	c := &Command{
+		Argv:   argv,
+		Path:   pathList(env),
+		Env:    buildExecEnv(env),
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	c.Dir, _ = r.FileSystem().Getwd()
+	fds := base.clone()
+	files, err := applyRedirs(p, r, &c.Stdin, &c.Stdout, &c.Stderr, fds)
+	if err != nil {
+		closeAll(files)
+		return nil, func() {}, err
+	}
+	extra, bridged, wait, err := fds.extraFiles(&c.Stdout, &c.Stderr)
+	if err != nil {
+		closeAll(files)
+		return nil, func() {}, err
+	}
+	c.Files = extra
+	cleanup := func() {
+		closeAll(files)
+		closeFiles(bridged)
+		wait()
+	}
+	return c, cleanup, nil
+}
+
+// startCommand starts c with the runner's executor. When it fails the
+// status says why, and a program that was not found is reported on
+// stderr.
+func (r *Runner) startCommand(c *Command, stderr io.Writer) (Process, Status) {
+	proc, err := r.executor().Start(c)
+	if errors.Is(err, ErrNotFound) {
+		fmt.Fprintf(stderr, "rc: cannot find `%s`\n", c.Argv[0])
+		return nil, StatusCode(127)
+	}
+	if err != nil {
+		return nil, errStatus(err)
+	}
+	return proc, nil
+}
@@ -0,0 +1,88 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func runExecutorInput(t *testing.T, r *Runner, input string, stdout io.Writer) Result {
+	t.Helper()
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	return r.RunPlan(plan, strings.NewReader(""), stdout, io.Discard)
+}
+
+func TestFakeExecutor(t *testing.T) {
+	fake := &FakeExecutor{Programs: map[string]FakeProgram{
+		"deploy": {Stdout: "ok\n", Status: Status{"3"}},
+		"kubectl": {
+			Stdout:    "applied\n",
+			ReadStdin: true,
+		},
+		"render": {Stdout: "kind: Pod\n"},
+	}}
+	r := &Runner{Env: NewEnv(nil), Executor: fake}
+	var out bytes.Buffer
+	res := runExecutorInput(t, r, "env=prod deploy -n 1\nrender | kubectl apply -f -\nx=`{render}\nmissing\n", &out)
+	if res.List.String() != "127" {
+		t.Fatalf("unexpected status: %q", res.List)
+	}
+	if out.String() != "ok\napplied\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	var argvs []string
+	for _, c := range fake.Calls() {
+		argvs = append(argvs, strings.Join(c.Argv, " "))
+	}
+	if strings.Join(argvs, ",") != "deploy -n 1,render,kubectl apply -f -,render" {
+		t.Fatalf("unexpected calls: %q", argvs)
+	}
+	calls := fake.Calls()
+	if !hasEntry(calls[0].Env, "env=prod") {
+		t.Fatalf("the prefix assignment is missing from the environment")
+	}
+	if calls[2].Stdin != "kind: Pod\n" {
+		t.Fatalf("unexpected kubectl input: %q", calls[2].Stdin)
+	}
+	if got := strings.Join(r.Env.Get("x"), " "); got != "kind: Pod" {
+		t.Fatalf("unexpected backquote value: %q", got)
+	}
+
+	res = runExecutorInput(t, r, "deploy &\nwait $apid\n", io.Discard)
+	if res.List.String() != "3" {
+		t.Fatalf("unexpected background status: %q", res.List)
+	}
+}
+
+func TestFakeExecutorFallback(t *testing.T) {
+	if !haveCmd(t, "printf") {
+		t.Skip("printf not available")
+	}
+	fake := &FakeExecutor{Fallback: OSExecutor{}}
+	r := &Runner{Env: NewEnv(nil), Executor: fake}
+	var out bytes.Buffer
+	runExecutorInput(t, r, "printf real\n", &out)
+	if out.String() != "real" || len(fake.Calls()) != 0 {
+		t.Fatalf("unexpected fallback: %q %+v", out.String(), fake.Calls())
+	}
+}
+
+func hasEntry(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,6 +11,11 @@ import (
 	"grc/internal/parse"
 )
 
+// During expansion, glob metacharacters that come from quoted text,
+// variables or command output are escaped with a backslash, so that only
+// unquoted literal ones take part in globbing. The escapes are removed
+// before the words are returned.
+
 // ExpandWord expands a word node into a list of strings.
 func ExpandWord(n *parse.Node, env *Env) ([]string, error) {
 	if n == nil {
@@ -21,7 +25,7 @@ func ExpandWord(n *parse.Node, env *Env) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	return globWords(words)
+	return globWords(env.fileSystem(), words)
 }
 
 // ExpandWordNoGlob expands a word without globbing.
@@ -29,7 +33,14 @@ func ExpandWordNoGlob(n *parse.Node, env *Env) ([]string, error) {
 	if n == nil {
 		return nil, nil
 	}
-	return expandWordBase(n, env)
+	words, err := expandWordBase(n, env)
+	if err != nil {
+		return nil, err
+	}
+	for i, w := range words {
+		words[i] = unescapeGlob(w)
+	}
+	return words, nil
 }
 
 func expandWordBase(n *parse.Node, env *Env) ([]string, error) {
@@ -38,7 +49,10 @@ func expandWordBase(n *parse.Node, env *Env) ([]string, error) {
 	}
 	switch n.Kind {
 	case parse.KWord:
-		return []string{n.Tok}, nil
+		if n.I1 != 0 {
+			return []string{escapeGlob(n.Tok)}, nil
+		}
+		return []string{strings.ReplaceAll(n.Tok, `\`, `\\`)}, nil
 	case parse.KConcat:
 		left, err := expandWordBase(n.Left, env)
 		if err != nil {
@@ -66,9 +80,9 @@ func expandWordBase(n *parse.Node, env *Env) ([]string, error) {
 			if err != nil {
 				return nil, err
 			}
-			return applySubscript(vals, subs), nil
+			return escapeGlobs(applySubscript(vals, subs)), nil
 		}
-		return vals, nil
+		return escapeGlobs(vals), nil
 	case parse.KFlat:
 		if n.Left == nil || n.Left.Kind != parse.KWord {
 			return nil, fmt.Errorf("unsupported flat node")
@@ -77,7 +91,7 @@ func expandWordBase(n *parse.Node, env *Env) ([]string, error) {
 		if vals == nil || len(vals) == 0 {
 			return []string{""}, nil
 		}
-		return []string{strings.Join(vals, " ")}, nil
+		return []string{escapeGlob(strings.Join(vals, " "))}, nil
 	case parse.KCount:
 		if n.Left == nil || n.Left.Kind != parse.KWord {
 			return nil, fmt.Errorf("unsupported count node")
@@ -107,16 +121,16 @@ func expandWordBase(n *parse.Node, env *Env) ([]string, error) {
 			return nil, err
 		}
 		var out bytes.Buffer
-		runner := &Runner{Env: child}
+		runner := child.nestedRunner()
 		res := runner.RunPlan(plan, strings.NewReader(""), &out, io.Discard)
 		if env != nil {
-			env.SetStatus(res.Status)
+			env.SetStatusList(res.List)
 		}
 		fields := splitFields(out.String(), env, n.Left)
 		if len(fields) == 0 {
 			return []string{}, nil
 		}
-		return fields, nil
+		return escapeGlobs(fields), nil
 	default:
 		return nil, fmt.Errorf("unsupported word node: %v", n.Kind)
 	}
@@ -238,10 +252,10 @@ func concatProduct(left, right []string) []string {
 	return nil
 }
 
-func globWords(words []string) ([]string, error) {
+func globWords(fsys FS, words []string) ([]string, error) {
 	var out []string
 	for _, w := range words {
-		matches, err := GlobWord(w)
+		matches, err := GlobWord(fsys, w)
 		if err != nil {
 			return nil, err
 		}
@@ -250,22 +264,84 @@ func globWords(words []string) ([]string, error) {
 	return out, nil
 }
 
-// GlobWord expands glob patterns in w.
-func GlobWord(w string) ([]string, error) {
-	if !strings.ContainsAny(w, "*?[") {
-		return []string{w}, nil
-	}
-	matches, err := filepath.Glob(w)
+// GlobWord expands glob patterns in w against the files of fsys, or of
+// the process's directory when fsys is nil. A backslash makes the
+// character after it literal; a word without matches is returned
+// unescaped.
+func GlobWord(fsys FS, w string) ([]string, error) {
+	if !hasGlobMeta(w) {
+		return []string{unescapeGlob(w)}, nil
+	}
+	if fsys == nil {
+		fsys = &OSFS{}
+	}
+	matches, err := glob(fsys, w)
 	if err != nil {
 		return nil, err
 	}
 	if len(matches) == 0 {
-		return []string{w}, nil
+		return []string{unescapeGlob(w)}, nil
 	}
 	sort.Strings(matches)
 	return matches, nil
 }
 
+func escapeGlob(s string) string {
+	if !strings.ContainsAny(s, `*?[\`) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func escapeGlobs(vals []string) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = escapeGlob(v)
+	}
+	return out
+}
+
+func unescapeGlob(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// hasGlobMeta reports whether s holds an unescaped glob metacharacter.
+func hasGlobMeta(s string) bool {
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*' || r == '?' || r == '[':
+			return true
+		}
+	}
+	return false
+}
+
 func splitFields(s string, env *Env, ifsOverride *parse.Node) []string {
 	if s == "" {
 		return []string{}
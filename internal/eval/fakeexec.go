@@ -0,0 +1,136 @@
+package eval
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// FakeExecutor is an Executor for tests that runs no programs. It records
+// every command it is asked to start and answers it from Programs, by the
+// command name as written. A command with no entry goes to Fallback, or
+// is not found when Fallback is nil. A faked command has no pid, so the
+// runner never signals or reaps it as a process of the system.
+type FakeExecutor struct {
+	Programs map[string]FakeProgram
+	Fallback Executor
+
+	mu    sync.Mutex
+	calls []*FakeCall
+}
+
+// FakeProgram is the scripted behaviour of a faked command.
+type FakeProgram struct {
+	Stdout    string // written to the command's standard output
+	Stderr    string // written to its standard error
+	Status    Status // exit status; nil means success
+	ReadStdin bool   // read standard input to EOF into FakeCall.Stdin
+}
+
+// FakeCall is a command a FakeExecutor was asked to start.
+type FakeCall struct {
+	Argv  []string
+	Env   []string
+	Dir   string
+	Stdin string
+}
+
+// Calls returns the commands started so far, oldest first.
+func (f *FakeExecutor) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]FakeCall, len(f.calls))
+	for i, c := range f.calls {
+		out[i] = *c
+	}
+	return out
+}
+
+// Start records c and, when it names a program in Programs, plays that
+// program back. The output is written in the background, as a process
+// would, so that a pipeline does not block on it.
+func (f *FakeExecutor) Start(c *Command) (Process, error) {
+	prog, ok := f.Programs[c.Argv[0]]
+	if !ok {
+		if f.Fallback != nil {
+			return f.Fallback.Start(c)
+		}
+		return nil, ErrNotFound
+	}
+	call := &FakeCall{Argv: append([]string{}, c.Argv...), Env: c.Env, Dir: c.Dir}
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+
+	// Like a child process, the fake keeps its own copies of descriptors
+	// so that the shell can close its ends once the command has started.
+	var held []*os.File
+	hold := func(v any) (any, error) {
+		file, ok := v.(*os.File)
+		if !ok {
+			return v, nil
+		}
+		fd, err := unix.FcntlInt(file.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			return nil, err
+		}
+		dup := os.NewFile(uintptr(fd), file.Name())
+		held = append(held, dup)
+		return dup, nil
+	}
+	in, err1 := hold(c.Stdin)
+	out, err2 := hold(c.Stdout)
+	errOut, err3 := hold(c.Stderr)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		closeFiles(held)
+		return nil, err
+	}
+	stdin, _ := in.(io.Reader)
+	stdout, _ := out.(io.Writer)
+	stderr, _ := errOut.(io.Writer)
+
+	p := &fakeProcess{status: prog.Status, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		defer closeFiles(held)
+		if prog.ReadStdin && stdin != nil {
+			data, _ := io.ReadAll(stdin)
+			f.mu.Lock()
+			call.Stdin = string(data)
+			f.mu.Unlock()
+		}
+		if prog.Stdout != "" && stdout != nil {
+			_, _ = io.WriteString(stdout, prog.Stdout)
+		}
+		if prog.Stderr != "" && stderr != nil {
+			_, _ = io.WriteString(stderr, prog.Stderr)
+		}
+	}()
+	return p, nil
+}
+
+type fakeProcess struct {
+	status Status
+	done   chan struct{}
+}
+
+func (p *fakeProcess) Pid() int {
+	return 0
+}
+
+// Signal is ignored: a fake process ends once its output is written.
+func (p *fakeProcess) Signal(sig syscall.Signal) error {
+	return nil
+}
+
+func (p *fakeProcess) Wait() Status {
+	<-p.done
+	if p.status == nil {
+		return statusTrue
+	}
+	return p.status
+}
@@ -0,0 +1,174 @@
+package eval
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// fdTable holds the descriptors above stderr visible to one invocation.
+// Entries are *os.File values, or the reader or writer of a standard
+// stream that was dup'd onto a higher descriptor.
+type fdTable map[int]any
+
+func (t fdTable) clone() fdTable {
+	out := make(fdTable, len(t))
+	for fd, v := range t {
+		out[fd] = v
+	}
+	return out
+}
+
+// extraFiles converts the table into exec.Cmd.ExtraFiles, where entry i
+// becomes descriptor 3+i in the child. Entries that are not files are
+// bridged through pipes, one pipe per writer; a stdout or stderr that
+// shares a bridged writer is pointed at the same pipe so the writer never
+// sees two concurrent copies. The returned files are the child ends of
+// those pipes; the caller closes them once the command has started or
+// finished. wait blocks until bridged output has been copied to its writer.
+func (t fdTable) extraFiles(stdout, stderr *io.Writer) ([]*os.File, []*os.File, func(), error) {
+	top := -1
+	for fd := range t {
+		if fd > top {
+			top = fd
+		}
+	}
+	if top < 3 {
+		return nil, nil, func() {}, nil
+	}
+	extra := make([]*os.File, top-2)
+	var child []*os.File
+	var wg sync.WaitGroup
+	bridged := make(map[io.Writer]*os.File)
+	for fd, v := range t {
+		if fd < 3 {
+			continue
+		}
+		switch v := v.(type) {
+		case *os.File:
+			extra[fd-3] = v
+		case io.Writer:
+			if pw, ok := lookupWriter(bridged, v); ok {
+				extra[fd-3] = pw
+				continue
+			}
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				closeFiles(child)
+				return nil, nil, func() {}, err
+			}
+			extra[fd-3] = pw
+			child = append(child, pw)
+			if isComparable(v) {
+				bridged[v] = pw
+			}
+			wg.Add(1)
+			go func(w io.Writer) {
+				defer wg.Done()
+				_, _ = io.Copy(w, pr)
+				_ = pr.Close()
+			}(v)
+		case io.Reader:
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				closeFiles(child)
+				return nil, nil, func() {}, err
+			}
+			extra[fd-3] = pr
+			child = append(child, pr)
+			go func(rd io.Reader) {
+				_, _ = io.Copy(pw, rd)
+				_ = pw.Close()
+			}(v)
+		}
+	}
+	for _, std := range []*io.Writer{stdout, stderr} {
+		if std == nil {
+			continue
+		}
+		if _, ok := (*std).(*os.File); ok {
+			continue
+		}
+		if pw, ok := lookupWriter(bridged, *std); ok {
+			*std = pw
+		}
+	}
+	return extra, child, wg.Wait, nil
+}
+
+func lookupWriter(m map[io.Writer]*os.File, w io.Writer) (*os.File, bool) {
+	if w == nil || !isComparable(w) {
+		return nil, false
+	}
+	f, ok := m[w]
+	return f, ok
+}
+
+func isComparable(v any) bool {
+	return reflect.TypeOf(v).Comparable()
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+func closeAll(files []io.Closer) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// stageIO is the descriptor set a single pipeline stage runs with.
+type stageIO struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	fds    fdTable
+}
+
+func newStageIO(stdin io.Reader, stdout, stderr io.Writer, base fdTable) stageIO {
+	return stageIO{stdin: stdin, stdout: stdout, stderr: stderr, fds: base.clone()}
+}
+
+// place connects f to descriptor fd of the stage.
+func (s *stageIO) place(fd int, f *os.File) error {
+	return assignFD(fd, &s.stdin, &s.stdout, &s.stderr, s.fds, f)
+}
+
+// lockedWriter serialises the writes of pipeline stages that share a writer
+// which is not a file. os/exec copies into such a writer from one goroutine
+// per process, and a bytes.Buffer loses data when two of them call ReadFrom
+// at once.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// shareWriters wraps the standard writers of a pipeline so that its stages
+// can write to them concurrently. Files are left alone; a writer used for
+// both streams gets a single lock.
+func shareWriters(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	wrap := func(w io.Writer) io.Writer {
+		if w == nil {
+			return nil
+		}
+		if _, ok := w.(*os.File); ok {
+			return w
+		}
+		return &lockedWriter{w: w}
+	}
+	out := wrap(stdout)
+	if stdout != nil && stderr != nil && isComparable(stdout) && isComparable(stderr) && stdout == stderr {
+		return out, out
+	}
+	return out, wrap(stderr)
+}
@@ -0,0 +1,276 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func TestRedirHighFD(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "fd3")
+	env := NewEnv(nil)
+	input := "sh -c 'printf three >&3' >[3] " + outPath + "\n"
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+	if res.Status != 0 {
+		t.Fatalf("expected status 0, got %d", res.Status)
+	}
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if string(data) != "three" {
+		t.Fatalf("unexpected file contents: %q", string(data))
+	}
+}
+
+func TestRedirHighFDInput(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "in")
+	if err := os.WriteFile(inPath, []byte("hi"), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	env := NewEnv(nil)
+	input := "sh -c 'cat <&3' <[3] " + inPath + "\n"
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if res.Status != 0 {
+		t.Fatalf("expected status 0, got %d", res.Status)
+	}
+	if out.String() != "hi" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestDupHighFDToStdout(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	env := NewEnv(nil)
+	input := "sh -c 'printf to4 >&4' >[4=1]\n"
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if res.Status != 0 {
+		t.Fatalf("expected status 0, got %d", res.Status)
+	}
+	if out.String() != "to4" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestDupCloseHighFD(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	env := NewEnv(nil)
+	input := "sh -c 'printf x >&4' >[4=1] >[4=]\n"
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if res.Status == 0 {
+		t.Fatalf("expected failure writing to closed fd")
+	}
+	if out.String() != "" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestFnHighFDInherited(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	env := NewEnv(nil)
+	input := "fn f { sh -c 'printf side >&3' }\nf >[3=1]\n"
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	if res.Status != 0 {
+		t.Fatalf("expected status 0, got %d", res.Status)
+	}
+	if out.String() != "side" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestBraceRedirs(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "cat") {
+		t.Skip("sh or cat not available")
+	}
+	dir := t.TempDir()
+	both := filepath.Join(dir, "both")
+	fd3 := filepath.Join(dir, "fd3")
+	input := "{ sh -c 'echo a'; sh -c 'echo b' } > " + both + "\n" +
+		"{ sh -c 'echo x' >[1=3] } >[3] " + fd3 + "\n" +
+		"cat " + both + " " + fd3 + "\n" +
+		"sh -c 'echo y >&3' >[2=] || sh -c 'echo closed'\n"
+	out, status := runFDInput(t, input)
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if out != "a\nb\nx\nclosed\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPlanDupInArgs(t *testing.T) {
+	ast, err := parse.Parse(strings.NewReader("echo hi >[4=1] >[7=]\n"))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, NewEnv(nil))
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	if len(plan.Argv) != 2 {
+		t.Fatalf("unexpected argv: %v", plan.Argv)
+	}
+	if len(plan.Redirs) != 2 {
+		t.Fatalf("expected 2 redirs, got %d", len(plan.Redirs))
+	}
+	if r := plan.Redirs[0]; r.Op != "dup" || r.Fd != 4 || r.DupTo != 1 || r.Close {
+		t.Fatalf("unexpected dup: %+v", r)
+	}
+	if r := plan.Redirs[1]; r.Op != "dup" || r.Fd != 7 || !r.Close {
+		t.Fatalf("unexpected close: %+v", r)
+	}
+}
+
+func runFDInput(t *testing.T, input string) (string, int) {
+	t.Helper()
+	env := NewEnv(nil)
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	return out.String(), res.Status
+}
+
+func TestPipeStderr(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "cat") {
+		t.Skip("sh or cat not available")
+	}
+	out, status := runFDInput(t, "sh -c 'echo err >&2' |[2] cat\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if out != "err\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPipeToHighFD(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	out, status := runFDInput(t, "echo hi |[1=3] sh -c 'cat <&3'\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if out != "hi\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPipeToHighFDLeavesStdin(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "cat") {
+		t.Skip("sh or cat not available")
+	}
+	env := NewEnv(nil)
+	ast, err := parse.ParseAll(strings.NewReader("cat |[1=3] sh -c 'cat <&3; cat'\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader("in\n"), &out, io.Discard)
+	if res.Status != 0 {
+		t.Fatalf("expected status 0, got %d", res.Status)
+	}
+	if out.String() != "in\n" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestPipeStderrFromFunction(t *testing.T) {
+	if !haveCmd(t, "tr") {
+		t.Skip("tr not available")
+	}
+	out, status := runFDInput(t, "fn f { echo loud >[1=2] }\nf |[2] tr a-z A-Z\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if out != "LOUD\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPipeThreeStages(t *testing.T) {
+	if !haveCmd(t, "tr") || !haveCmd(t, "wc") {
+		t.Skip("tr or wc not available")
+	}
+	out, status := runFDInput(t, "echo abc | tr a-z A-Z | wc -c\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if strings.TrimSpace(out) != "4" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
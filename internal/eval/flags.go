@@ -0,0 +1,98 @@
+package eval
+
+import (
+	"io"
+
+	"grc/internal/parse"
+)
+
+// Flag reports whether the single-letter shell flag c is set. The x flag
+// is the Trace field; the others are kept by the runner.
+func (r *Runner) Flag(c byte) bool {
+	if r == nil {
+		return false
+	}
+	if c == 'x' {
+		return r.Trace
+	}
+	r = r.shell()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.flags[c]
+}
+
+// SetFlag sets or clears the shell flag c.
+func (r *Runner) SetFlag(c byte, on bool) {
+	if r == nil {
+		return
+	}
+	if c == 'x' {
+		r.Trace = on
+		return
+	}
+	r = r.shell()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.flags == nil {
+		r.flags = make(map[byte]bool)
+	}
+	r.flags[c] = on
+}
+
+// subshellFlags returns the command line flags a subshell inherits.
+func (r *Runner) subshellFlags() []string {
+	var letters []byte
+	for _, c := range []byte{'e', 'v', 'x'} {
+		if r.Flag(c) {
+			letters = append(letters, c)
+		}
+	}
+	if len(letters) == 0 {
+		return nil
+	}
+	return []string{"-" + string(letters)}
+}
+
+// Input returns rd wrapped so that, while the v flag is set, everything
+// read from it is echoed to w.
+func (r *Runner) Input(rd io.Reader, w io.Writer) io.Reader {
+	return &verboseReader{r: r, rd: rd, w: w}
+}
+
+type verboseReader struct {
+	r  *Runner
+	rd io.Reader
+	w  io.Writer
+}
+
+func (v *verboseReader) Read(p []byte) (int, error) {
+	n, err := v.rd.Read(p)
+	if n > 0 && v.w != nil && v.r.Flag('v') {
+		_, _ = v.w.Write(p[:n])
+	}
+	return n, err
+}
+
+// checkErrExit ends the shell when the e flag is set and the simple
+// command or pipeline p failed outside a condition. Commands tested by
+// if, while, ! and the left side of && or || are exempt.
+func (r *Runner) checkErrExit(p *ExecPlan, status Status) {
+	if status.OK() || r.condDepth > 0 || !r.Flag('e') {
+		return
+	}
+	if p.IfOK != nil || p.IfFail != nil {
+		return
+	}
+	if p.Kind != PlanCmd && p.PipeTo == nil {
+		return
+	}
+	r.exitRequested = true
+	r.exitStatus = status
+}
+
+// runCond runs n as a condition, where a failure does not trigger -e.
+func (r *Runner) runCond(n *parse.Node, stdin io.Reader, stdout, stderr io.Writer) Status {
+	r.condDepth++
+	defer func() { r.condDepth-- }()
+	return r.runAST(n, stdin, stdout, stderr)
+}
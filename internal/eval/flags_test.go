@@ -0,0 +1,85 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func runFlagInput(t *testing.T, r *Runner, input string) string {
+	t.Helper()
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	r.RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	return out.String()
+}
+
+func TestErrExitStopsOnFailure(t *testing.T) {
+	if !haveCmd(t, "false") {
+		t.Skip("false not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	r.SetFlag('e', true)
+	out := runFlagInput(t, r, "fn f { false; echo in f }\nf\necho after\n")
+	if out != "" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+	if !r.ExitRequested() || r.ExitCode() != 1 {
+		t.Fatalf("expected exit 1, got %v %d", r.ExitRequested(), r.ExitCode())
+	}
+}
+
+func TestErrExitExemptions(t *testing.T) {
+	if !haveCmd(t, "false") {
+		t.Skip("false not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	r.SetFlag('e', true)
+	input := "if(false) echo no\nfalse || echo or\nfalse && echo no\n! false\nx=1\nwhile(false) echo no\necho done\n"
+	out := runFlagInput(t, r, input)
+	if out != "or\ndone\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+	if r.ExitRequested() {
+		t.Fatalf("no command should have ended the shell")
+	}
+}
+
+func TestFlagBuiltin(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	out := runFlagInput(t, r, "flag e || echo unset\nflag e +\nflag e && echo set\n")
+	if out != "unset\nset\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+	if !r.Flag('e') {
+		t.Fatalf("expected flag e to stay set")
+	}
+	runFlagInput(t, r, "flag e -\nflag x +\n")
+	if r.Flag('e') || !r.Trace {
+		t.Fatalf("unexpected flags: e=%v x=%v", r.Flag('e'), r.Trace)
+	}
+}
+
+func TestVerboseInput(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	var echo bytes.Buffer
+	data, _ := io.ReadAll(r.Input(strings.NewReader("quiet\n"), &echo))
+	if string(data) != "quiet\n" || echo.Len() != 0 {
+		t.Fatalf("unexpected read %q, echo %q", data, echo.String())
+	}
+	r.SetFlag('v', true)
+	data, _ = io.ReadAll(r.Input(strings.NewReader("loud\n"), &echo))
+	if string(data) != "loud\n" || echo.String() != "loud\n" {
+		t.Fatalf("unexpected read %q, echo %q", data, echo.String())
+	}
+}
@@ -0,0 +1,518 @@
+package eval
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// FS is the file system a Runner works in. Redirections and . open files
+// through it, globbing and $path lookups read it, and cd and pwd move and
+// report its working directory. Relative names are taken from that
+// directory, so each Runner with its own FS has a current directory of
+// its own. A Runner with no FS uses an OSFS.
+type FS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Chdir(dir string) error
+	Getwd() (string, error)
+}
+
+// File is a file opened by an FS. It is an io.Reader when opened for
+// reading and an io.Writer when opened for writing. An *os.File is given
+// to external commands as the descriptor itself; any other File is copied
+// through a pipe.
+type File interface {
+	io.Closer
+}
+
+// FileSystem returns the runner's FS, setting it to an OSFS in the
+// process's directory when it is nil.
+func (r *Runner) FileSystem() FS {
+	if r.FS == nil {
+		r.FS = NewOSFS("")
+	}
+	return r.FS
+}
+
+// fileSystem returns the FS of the runner of the plan using e, or an OSFS
+// in the process's directory when no plan is running.
+func (e *Env) fileSystem() FS {
+	for cur := e; cur != nil; cur = cur.parent {
+		if r := cur.runner; r != nil {
+			return r.FileSystem()
+		}
+	}
+	return &OSFS{}
+}
+
+// WorkingDir returns the current directory as $pwd names it, keeping the
+// path taken through symbolic links, or the directory of the runner's FS
+// when $pwd is unset or stale.
+func (r *Runner) WorkingDir() (string, error) {
+	fsys := r.FileSystem()
+	cwd, err := fsys.Getwd()
+	if r.Env == nil {
+		return cwd, err
+	}
+	if pwd := r.Env.Get("pwd"); len(pwd) == 1 && filepath.IsAbs(pwd[0]) {
+		if err == nil && pwd[0] == cwd {
+			return cwd, nil
+		}
+		a, err1 := fsys.Stat(pwd[0])
+		b, err2 := fsys.Stat(".")
+		if err1 == nil && err2 == nil && os.SameFile(a, b) {
+			return pwd[0], nil
+		}
+	}
+	return cwd, err
+}
+
+// OSFS is the operating system's file system, seen from a working
+// directory of its own. Changing it does not change the directory of the
+// process, which other runners and the rest of the program keep using.
+type OSFS struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewOSFS returns an OSFS working in dir, or in the process's directory,
+// until its first Chdir, when dir is empty.
+func NewOSFS(dir string) *OSFS {
+	return &OSFS{dir: dir}
+}
+
+// path names name from the process's directory. The working directory is
+// prefixed rather than joined, so that .. after a symbolic link is left
+// for the kernel to resolve, as it would be from the directory itself.
+func (f *OSFS) path(name string) string {
+	f.mu.Lock()
+	dir := f.dir
+	f.mu.Unlock()
+	if dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// pathError puts back the name the caller used into an error about the
+// path it was resolved to.
+func pathError(err error, name string) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+func (f *OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := os.OpenFile(f.path(name), flag, perm)
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+	return file, nil
+}
+
+func (f *OSFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := os.Stat(f.path(name))
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+	return info, nil
+}
+
+func (f *OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(f.path(name))
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+	return entries, nil
+}
+
+// Chdir makes dir the working directory. An absolute dir is kept as it is
+// written, cleaned, so that it can name the directory through symbolic
+// links; a relative one is resolved to the physical directory.
+func (f *OSFS) Chdir(dir string) error {
+	target := dir
+	if !filepath.IsAbs(dir) {
+		target = f.path(dir)
+		if !filepath.IsAbs(target) {
+			wd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			target = wd + "/" + target
+		}
+		resolved, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			return &fs.PathError{Op: "chdir", Path: dir, Err: unwrapPathError(err)}
+		}
+		target = resolved
+	}
+	target = filepath.Clean(target)
+	info, err := os.Stat(target)
+	if err != nil {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: unwrapPathError(err)}
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	if err := unix.Access(target, unix.X_OK); err != nil {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: err}
+	}
+	f.mu.Lock()
+	f.dir = target
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *OSFS) Getwd() (string, error) {
+	f.mu.Lock()
+	dir := f.dir
+	f.mu.Unlock()
+	if dir == "" {
+		return os.Getwd()
+	}
+	return dir, nil
+}
+
+// Fork returns an OSFS with a working directory of its own, starting at
+// f's.
+func (f *OSFS) Fork() FS {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &OSFS{dir: f.dir}
+}
+
+// forkFS returns a file system with the files of fsys and a working
+// directory of its own, for a runner that works alongside the shell as a
+// forked rc would. An FS without a Fork method is shared as it is.
+func forkFS(fsys FS) FS {
+	if f, ok := fsys.(interface{ Fork() FS }); ok {
+		return f.Fork()
+	}
+	return fsys
+}
+
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+// MemFS is a file system held in memory, for tests and for embedders
+// that run scripts without touching the disk. It starts empty, with the
+// working directory at the root. External commands run by OSExecutor do
+// not see it.
+type MemFS struct {
+	mu    *sync.Mutex         // shared with forks, as nodes is
+	nodes map[string]*memNode // by clean absolute path
+	dir   string
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		mu:    new(sync.Mutex),
+		nodes: map[string]*memNode{"/": {mode: fs.ModeDir | 0o755, modTime: time.Now()}},
+		dir:   "/",
+	}
+}
+
+// abs returns name as a clean absolute path. The caller holds m.mu.
+func (m *MemFS) abs(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(m.dir, name)
+}
+
+// parentDir reports why p cannot be created, or nil when its parent is a
+// directory. The caller holds m.mu.
+func (m *MemFS) parentDir(p string) error {
+	parent, ok := m.nodes[path.Dir(p)]
+	switch {
+	case !ok:
+		return fs.ErrNotExist
+	case !parent.mode.IsDir():
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.abs(name)
+	n, ok := m.nodes[p]
+	switch {
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		if err := m.parentDir(p); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		n = &memNode{mode: perm.Perm(), modTime: time.Now()}
+		m.nodes[p] = n
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case n.mode.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	if access != os.O_RDONLY && flag&os.O_TRUNC != 0 {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	f := &memFile{fs: m, node: n, append: flag&os.O_APPEND != 0}
+	switch access {
+	case os.O_RDONLY:
+		return struct {
+			io.Reader
+			io.Closer
+		}{f, f}, nil
+	case os.O_WRONLY:
+		return struct {
+			io.Writer
+			io.Closer
+		}{f, f}, nil
+	}
+	return f, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.abs(name)
+	n, ok := m.nodes[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return n.info(path.Base(p)), nil
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.abs(name)
+	n, ok := m.nodes[p]
+	switch {
+	case !ok:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !n.mode.IsDir():
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+	var entries []fs.DirEntry
+	for child, cn := range m.nodes {
+		if child != "/" && path.Dir(child) == p {
+			entries = append(entries, fs.FileInfoToDirEntry(cn.info(path.Base(child))))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) Chdir(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.abs(dir)
+	n, ok := m.nodes[p]
+	switch {
+	case !ok:
+		return &fs.PathError{Op: "chdir", Path: dir, Err: fs.ErrNotExist}
+	case !n.mode.IsDir():
+		return &fs.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	m.dir = p
+	return nil
+}
+
+func (m *MemFS) Getwd() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dir, nil
+}
+
+// Fork returns a MemFS holding the same files with a working directory of
+// its own, starting at m's.
+func (m *MemFS) Fork() FS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &MemFS{mu: m.mu, nodes: m.nodes, dir: m.dir}
+}
+
+// MkdirAll creates the directory name and any parents it lacks.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.abs(name)
+	var dirs []string
+	for cur := p; cur != "/"; cur = path.Dir(cur) {
+		dirs = append(dirs, cur)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		n, ok := m.nodes[dirs[i]]
+		if !ok {
+			m.nodes[dirs[i]] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+			continue
+		}
+		if !n.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// WriteFile writes data to the file name, creating it with perm if it
+// does not exist.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.(io.Writer).Write(data)
+	return err
+}
+
+// ReadFile returns the contents of the file name.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	f, err := m.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f.(io.Reader))
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return memInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memFile is an open MemFS file. Reads and writes go straight to the
+// node, so other opens of the same file see them at once.
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	off    int
+	append bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.off >= len(f.node.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.append {
+		f.off = len(f.node.data)
+	}
+	if end := f.off + len(p); end > len(f.node.data) {
+		f.node.data = append(f.node.data[:f.off], make([]byte, end-f.off)...)
+	}
+	copy(f.node.data[f.off:], p)
+	f.off += len(p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// glob returns the names in fsys matching pattern, as filepath.Glob does
+// for the process's file system.
+func glob(fsys FS, pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if !hasMeta(pattern) {
+		if _, err := fsys.Stat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+	dir, file := filepath.Split(pattern)
+	dir = cleanGlobPath(dir)
+	if !hasMeta(dir) {
+		return globDir(fsys, dir, file, nil), nil
+	}
+	if dir == pattern {
+		return nil, filepath.ErrBadPattern
+	}
+	dirs, err := glob(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, d := range dirs {
+		matches = globDir(fsys, d, file, matches)
+	}
+	return matches, nil
+}
+
+// globDir appends the entries of dir that match pattern to matches.
+func globDir(fsys FS, dir, pattern string, matches []string) []string {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return matches
+	}
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(pattern, entry.Name()); ok {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return matches
+}
+
+func cleanGlobPath(dir string) string {
+	switch dir {
+	case "":
+		return "."
+	case "/":
+		return dir
+	}
+	return dir[:len(dir)-1]
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
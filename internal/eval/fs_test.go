@@ -0,0 +1,138 @@
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	mem := NewMemFS()
+	if err := mem.MkdirAll("/work/sub", 0o755); err != nil {
+		t.Fatalf("MkdirAll returned error: %v", err)
+	}
+	if err := mem.WriteFile("/work/setup.rc", []byte("echo from script\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	fake := &FakeExecutor{Programs: map[string]FakeProgram{"deploy": {}}}
+	r := &Runner{Env: NewEnv(nil), FS: mem, Executor: fake}
+	r.SetBuiltin("echo", func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+		fmt.Fprintln(stdout, strings.Join(args[1:], " "))
+		return 0
+	})
+	var out bytes.Buffer
+	input := "cd /work\necho hi > out\necho again >> out\n. setup.rc > log\necho *\ncd sub\npwd\necho ../*.rc\ndeploy\ncd /missing\n"
+	res := runExecutorInput(t, r, input, &out)
+	if res.List.String() != "1" {
+		t.Fatalf("unexpected status: %q", res.List)
+	}
+	if out.String() != "log out setup.rc sub\n/work/sub\n../setup.rc\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	for name, want := range map[string]string{"/work/out": "hi\nagain\n", "/work/log": "from script\n"} {
+		data, err := mem.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Fatalf("unexpected %s: %q %v", name, data, err)
+		}
+	}
+	if calls := fake.Calls(); len(calls) != 1 || calls[0].Dir != "/work/sub" {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	if pwd := r.Env.Get("pwd"); len(pwd) != 1 || pwd[0] != "/work/sub" {
+		t.Fatalf("unexpected $pwd: %q", pwd)
+	}
+}
+
+func TestOSFSWorkingDir(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "echo") {
+		t.Skip("sh or echo not available")
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+
+	a := &Runner{Env: NewEnv(nil), FS: NewOSFS(dir)}
+	b := &Runner{Env: NewEnv(nil), FS: NewOSFS(dir)}
+	var out bytes.Buffer
+	runExecutorInput(t, a, "cd link\necho x > f\npwd\n", &out)
+	runExecutorInput(t, b, "pwd\necho *\n", &out)
+	want := filepath.Join(dir, "link") + "\n" + dir + "\nlink sub\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "f")); err != nil {
+		t.Fatalf("the redirection missed the runner's directory: %v", err)
+	}
+	if wd, _ := os.Getwd(); wd != old {
+		t.Fatalf("cd moved the process to %q", wd)
+	}
+
+	out.Reset()
+	runExecutorInput(t, a, "sh -c 'ls; pwd -P'\n", &out)
+	if out.String() != "f\n"+filepath.Join(dir, "sub")+"\n" {
+		t.Fatalf("unexpected command output: %q", out.String())
+	}
+}
+
+func TestCDInStageLeavesShell(t *testing.T) {
+	mem := NewMemFS()
+	if err := mem.MkdirAll("/usr", 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	r := &Runner{Env: NewEnv(nil), FS: mem}
+	var out bytes.Buffer
+	runExecutorInput(t, r, "cd /usr | pwd\n@{cd /usr}\nx=`{cd /usr; pwd}\npwd\n", &out)
+	if out.String() != "/\n/\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if x := r.Env.Get("x"); len(x) != 1 || x[0] != "/usr" {
+		t.Fatalf("unexpected backquote output: %q", x)
+	}
+	if pwd := r.Env.Get("pwd"); len(pwd) != 0 {
+		t.Fatalf("expected $pwd to stay unset, got %q", pwd)
+	}
+}
+
+func TestGlobFS(t *testing.T) {
+	mem := NewMemFS()
+	for _, name := range []string{"/a/x1", "/a/x2", "/b/x3", "/b/y"} {
+		if err := mem.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("MkdirAll returned error: %v", err)
+		}
+		if err := mem.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+	}
+	cases := map[string]string{
+		"/*/x*":   "/a/x1 /a/x2 /b/x3",
+		"/b/?":    "/b/y",
+		"a/x[2]":  "a/x2",
+		"/c/*":    "/c/*",
+		`/a/x\*`:  "/a/x*",
+		"/[ab]/y": "/b/y",
+	}
+	for pattern, want := range cases {
+		got, err := GlobWord(mem, pattern)
+		if err != nil || strings.Join(got, " ") != want {
+			t.Fatalf("GlobWord(%q) = %q %v, want %q", pattern, got, err, want)
+		}
+	}
+	if _, err := mem.OpenFile("/a", os.O_WRONLY, 0); err == nil {
+		t.Fatalf("opened a directory for writing")
+	}
+	if _, err := mem.OpenFile("/none/f", os.O_WRONLY|os.O_CREATE, 0o644); err == nil {
+		t.Fatalf("created a file in a missing directory")
+	}
+}
@@ -104,3 +104,33 @@ func TestGlobFromDollarConcat(t *testing.T) {
 		}
 	}
 }
+
+func TestGlobSkipsQuotedAndVariables(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a1.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	defer os.Chdir(old)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+
+	env := NewEnv(nil)
+	env.Set("x", []string{"a*"})
+	ast, err := parse.Parse(strings.NewReader("echo 'a*.txt' $x^.txt a'*'.txt a*.txt\n"))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	got := strings.Join(plan.Argv, " ")
+	if got != "echo a*.txt a*.txt a*.txt a1.txt" {
+		t.Fatalf("unexpected argv: %v", plan.Argv)
+	}
+}
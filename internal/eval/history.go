@@ -0,0 +1,155 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"grc/internal/history"
+	"grc/internal/parse"
+)
+
+// defaultHistorySize caps the history file when $historysize is unset.
+const defaultHistorySize = 10000
+
+// HistoryStore returns the history file named by $history, capped by
+// $historysize and deduplicated as $historydedup (adjacent, none or all)
+// asks. It returns nil when $history is unset.
+func HistoryStore(env *Env) *history.Store {
+	if env == nil {
+		return nil
+	}
+	path := env.Get("history")
+	if len(path) == 0 || path[0] == "" {
+		return nil
+	}
+	s := &history.Store{Path: path[0], Max: defaultHistorySize}
+	if v := env.Get("historysize"); len(v) > 0 {
+		if n, err := strconv.Atoi(v[0]); err == nil && n >= 0 {
+			s.Max = n
+		}
+	}
+	if v := env.Get("historydedup"); len(v) > 0 {
+		if d, ok := history.ParseDedup(v[0]); ok {
+			s.Dedup = d
+		}
+	}
+	return s
+}
+
+// builtinHistory lists, searches and re-runs history entries:
+//
+//	history [-t] [-g regexp]
+//	history -r [n]
+//
+// -t adds the time, status and directory of each entry. -r runs entry n,
+// or the latest one, again.
+func builtinHistory(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	usage := func() int {
+		fmt.Fprintln(stderr, "usage: history [-t] [-g regexp] | history -r [n]")
+		return 1
+	}
+	if r == nil || r.Env == nil {
+		return 1
+	}
+	var (
+		long  bool
+		grep  *regexp.Regexp
+		rerun bool
+		num   int
+	)
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-t":
+			long = true
+		case "-g":
+			if i+1 >= len(args) {
+				return usage()
+			}
+			i++
+			re, err := regexp.Compile(args[i])
+			if err != nil {
+				fmt.Fprintf(stderr, "history: %v\n", err)
+				return 1
+			}
+			grep = re
+		case "-r":
+			rerun = true
+			if i+1 < len(args) {
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 1 {
+					fmt.Fprintf(stderr, "history: bad entry number %s\n", args[i])
+					return 1
+				}
+				num = n
+			}
+		default:
+			return usage()
+		}
+	}
+	store := HistoryStore(r.Env)
+	if store == nil {
+		fmt.Fprintln(stderr, "history: $history is not set")
+		return 1
+	}
+	entries, err := store.Load()
+	if err != nil {
+		fmt.Fprintf(stderr, "history: %v\n", err)
+		return 1
+	}
+	if rerun {
+		if num == 0 {
+			num = len(entries)
+		}
+		if num < 1 || num > len(entries) {
+			fmt.Fprintf(stderr, "history: no entry %d\n", num)
+			return 1
+		}
+		return rerunHistory(entries[num-1].Line, stdin, stdout, stderr, r)
+	}
+	for i, e := range entries {
+		if grep != nil && !grep.MatchString(e.Line) {
+			continue
+		}
+		line := strings.ReplaceAll(e.Line, "\n", "\n\t")
+		if !long {
+			fmt.Fprintf(stdout, "%5d\t%s\n", i+1, line)
+			continue
+		}
+		when := "-"
+		if !e.Time.IsZero() {
+			when = e.Time.Format("2006-01-02 15:04:05")
+		}
+		status := e.Status
+		if status == "" {
+			status = "-"
+		}
+		dir := e.Dir
+		if dir == "" {
+			dir = "-"
+		}
+		fmt.Fprintf(stdout, "%5d\t%s\t%s\t%s\t%s\n", i+1, when, status, dir, line)
+	}
+	return 0
+}
+
+func rerunHistory(src string, stdin io.Reader, stdout, stderr io.Writer, r *Runner) int {
+	fmt.Fprintln(stderr, src)
+	if !strings.HasSuffix(src, "\n") {
+		src += "\n"
+	}
+	ast, err := parse.ParseAll(strings.NewReader(src))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return r.builtinResult(r.runChain(plan, stdin, stdout, stderr))
+}
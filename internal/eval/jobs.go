@@ -1,13 +1,20 @@
 package eval
 
 import (
+	"fmt"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
-// Job tracks a background job.
+// Job tracks a process group: one started in the background, or a
+// foreground one that was stopped. State is "running", "stopped" or
+// "done".
 type Job struct {
 	ID       int
 	Pgid     int
@@ -17,50 +24,283 @@ type Job struct {
 	Exit     int
 	Notified bool
 	Done     chan int
+
+	procs   []Process      // the processes of Pids, when the shell started them
+	status  Status         // how each of Pids ended, once done
+	stopSig syscall.Signal // the signal that last stopped the job
+	modes   *unix.Termios  // terminal modes the job had when it stopped
+	fg      bool           // the shell is waiting for it in the foreground
+	changed chan struct{}  // closed and replaced whenever State changes
+	cleanup []func()       // run once every process has been reaped
+	env     *Env           // where $apid lists the job's processes
+}
+
+func newJob(pgid int, pids []int, cmd string) *Job {
+	return &Job{
+		Pgid:    pgid,
+		Pids:    append([]int{}, pids...),
+		Cmd:     cmd,
+		State:   "running",
+		Done:    make(chan int, 1),
+		changed: make(chan struct{}),
+	}
 }
 
 func (r *Runner) addJob(pgid int, pids []int, cmd string) *Job {
 	if r == nil {
 		return nil
 	}
+	job := newJob(pgid, pids, cmd)
+	r.registerJob(job)
+	return job
+}
+
+// registerJob gives job the next id and enters it in the job table.
+func (r *Runner) registerJob(job *Job) {
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if r.Jobs == nil {
 		r.Jobs = make(map[int]*Job)
 	}
 	r.nextJobID++
-	job := &Job{
-		ID:    r.nextJobID,
-		Pgid:  pgid,
-		Pids:  append([]int{}, pids...),
-		Cmd:   cmd,
-		State: "running",
-		Done:  make(chan int, 1),
-	}
-	r.Jobs[r.nextJobID] = job
-	return job
+	job.ID = r.nextJobID
+	r.Jobs[job.ID] = job
+}
+
+// setJobState changes the state of job and wakes everyone waiting on it.
+func (r *Runner) setJobState(job *Job, state string) {
+	r = r.shell()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setJobStateLocked(job, state)
+}
+
+func (r *Runner) setJobStateLocked(job *Job, state string) {
+	if job.State == state {
+		return
+	}
+	job.State = state
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// waitJobState blocks while job is in state and returns the state it
+// moved to.
+func (r *Runner) waitJobState(job *Job, state string) string {
+	r = r.shell()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for job.State == state {
+		ch := job.changed
+		r.mu.Unlock()
+		<-ch
+		r.mu.Lock()
+	}
+	return job.State
 }
 
-func (r *Runner) markJobDone(pgid int, exit int) {
+func (r *Runner) markJobDone(job *Job, status Status) {
 	if r == nil {
 		return
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	for _, job := range r.Jobs {
-		if job.Pgid == pgid {
-			job.State = "done"
-			job.Exit = exit
-			job.Notified = false
-			return
+	job.status = status
+	job.Exit = status.Code()
+	job.Notified = false
+	if r.notify == "immediate" && r.notifyTo != nil && job.ID != 0 && !job.fg {
+		fmt.Fprint(r.notifyTo, "\n"+jobReport(job))
+		job.Notified = true
+		delete(r.Jobs, job.ID)
+	}
+	r.setJobStateLocked(job, "done")
+}
+
+// NotifyJobs reports on w each job that has finished since the last call,
+// as "[1] done  sleep 5", and drops it from the job table. $notify changes
+// when that happens: off leaves finished jobs for the jobs builtin, and
+// immediate reports each job on w as soon as it finishes.
+func (r *Runner) NotifyJobs(w io.Writer) {
+	if r == nil || r.Env == nil {
+		return
+	}
+	r = r.shell()
+	mode := ""
+	if v := r.Env.Get("notify"); len(v) > 0 {
+		mode = v[0]
+	}
+	r.mu.Lock()
+	r.notify = mode
+	r.notifyTo = w
+	r.mu.Unlock()
+	if mode == "off" {
+		return
+	}
+	for _, job := range r.ListJobs() {
+		r.mu.Lock()
+		if job.State == "done" && !job.Notified {
+			fmt.Fprint(w, jobReport(job))
+			job.Notified = true
 		}
+		r.mu.Unlock()
 	}
+	r.pruneJobs()
+}
+
+// jobReport describes a finished job: done, exit and the status, or the
+// signal that killed it.
+func jobReport(job *Job) string {
+	how := "done"
+	if !job.status.OK() {
+		how = job.status.String()
+		if _, err := strconv.Atoi(how); err == nil {
+			how = "exit " + how
+		}
+	}
+	return "[" + strconv.Itoa(job.ID) + "] " + how + "  " + job.Cmd + "\n"
+}
+
+// waitJobPids reaps the processes of job as they stop, continue and exit,
+// keeping its state current. Once all of pids have exited it runs the
+// cleanup hooks and marks the job done.
+func (r *Runner) waitJobPids(job *Job, pids []int) {
+	if job == nil {
+		return
+	}
+	r = r.shell()
+	// Processes without a pid are not children of the shell; only Wait
+	// tells when they end.
+	children := 0
+	for _, pid := range pids {
+		if pid > 0 {
+			children++
+		}
+	}
+	ended := make(map[int]Status, len(pids))
+	for job.Pgid > 0 && len(ended) < children {
+		var ws unix.WaitStatus
+		pid, err := unix.Wait4(-job.Pgid, &ws, unix.WUNTRACED|unix.WCONTINUED, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		switch {
+		case ws.Stopped():
+			r.mu.Lock()
+			job.stopSig = ws.StopSignal()
+			r.setJobStateLocked(job, "stopped")
+			r.mu.Unlock()
+		case ws.Continued():
+			r.setJobState(job, "running")
+		default:
+			ended[pid] = waitStatus(ws)
+			r.apidExited(job.env, pid)
+		}
+	}
+	// Wait releases what the executor holds for each process. A process
+	// the loop could not reap, such as one that is not a child of the
+	// shell, gets its status from Wait instead.
+	status := make(Status, len(pids))
+	for i, pid := range pids {
+		st, reaped := ended[pid]
+		if i < len(job.procs) {
+			if s := job.procs[i].Wait(); !reaped {
+				st = s
+			}
+		}
+		if !reaped {
+			r.apidExited(job.env, pid)
+		}
+		status[i] = st.word()
+	}
+	for _, fn := range job.cleanup {
+		fn()
+	}
+	r.markJobDone(job, status)
+	select {
+	case job.Done <- job.Exit:
+	default:
+	}
+}
+
+// signalGroup sends sig to the process group pgid, if there is one, and to
+// each of procs that has no pid and so is in no group.
+func signalGroup(pgid int, procs []Process, sig syscall.Signal) error {
+	var err error
+	if pgid > 0 {
+		err = unix.Kill(-pgid, sig)
+	}
+	for _, p := range procs {
+		if p.Pid() != 0 {
+			continue
+		}
+		if perr := p.Signal(sig); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// waitStatus returns the status of a process that ended with ws.
+func waitStatus(ws unix.WaitStatus) Status {
+	if ws.Signaled() {
+		return Status{signalStatus(ws.Signal(), ws.CoreDump())}
+	}
+	return StatusCode(ws.ExitStatus())
+}
+
+// runForegroundJob waits for job, which is not yet in the job table, with
+// the terminal given to its process group. A job that stops instead of
+// finishing is entered in the table, reported on stderr, and its status
+// is the name of the stop signal; stopped reports that case.
+func (r *Runner) runForegroundJob(job *Job, stderr io.Writer) (status Status, stopped bool) {
+	go r.waitJobPids(job, job.Pids)
+	return r.foregroundJob(job, stderr)
+}
+
+// foregroundJob waits in the foreground for job to finish or stop. The
+// job gets back the terminal modes it had when it last stopped.
+func (r *Runner) foregroundJob(job *Job, stderr io.Writer) (Status, bool) {
+	r = r.shell()
+	r.mu.Lock()
+	modes := job.modes
+	r.mu.Unlock()
+	r.setTerminalModes(modes)
+	r.mu.Lock()
+	job.fg = true
+	r.mu.Unlock()
+	r.attachForegroundPgid(job.Pgid)
+	stop := r.killOnCancel(job.Pgid, job.procs)
+	state := r.waitJobState(job, "running")
+	stop()
+	if state == "stopped" {
+		modes = r.terminalModes()
+	}
+	r.restoreForeground()
+	r.mu.Lock()
+	status, sig := job.status, job.stopSig
+	job.modes = modes
+	job.fg = false
+	r.mu.Unlock()
+	if state == "done" {
+		return status, false
+	}
+	if job.ID == 0 {
+		r.registerJob(job)
+	}
+	fmt.Fprint(stderr, "\n"+formatJobs([]*Job{job}))
+	return Status{signalStatus(sig, false)}, true
 }
 
 func (r *Runner) findJobByPgid(pgid int) *Job {
 	if r == nil {
 		return nil
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for _, job := range r.Jobs {
@@ -75,6 +315,7 @@ func (r *Runner) findJobByPID(pid int) *Job {
 	if r == nil {
 		return nil
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for _, job := range r.Jobs {
@@ -94,29 +335,38 @@ func (r *Runner) waitJob(job *Job) int {
 	if job == nil {
 		return 1
 	}
-	if job.State == "done" {
-		return job.Exit
-	}
-	exit, ok := <-job.Done
-	if ok {
-		return exit
+	for r.waitJobState(job, "running") != "done" {
+		r.waitJobState(job, "stopped")
 	}
 	return job.Exit
 }
 
+// waitJobStatus waits for job like waitJob and returns its status, with a
+// word for each stage of a pipeline.
+func (r *Runner) waitJobStatus(job *Job) Status {
+	r.waitJob(job)
+	sh := r.shell()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return job.status
+}
+
 func (r *Runner) removeJob(id int) {
 	if r == nil {
 		return
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	delete(r.Jobs, id)
 }
 
-func (r *Runner) listJobs() []*Job {
+// ListJobs returns the jobs ordered by id.
+func (r *Runner) ListJobs() []*Job {
 	if r == nil {
 		return nil
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	jobs := make([]*Job, 0, len(r.Jobs))
@@ -133,6 +383,7 @@ func (r *Runner) pruneJobs() {
 	if r == nil {
 		return
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for id, job := range r.Jobs {
@@ -146,6 +397,7 @@ func (r *Runner) getJob(id int) *Job {
 	if r == nil {
 		return nil
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	return r.Jobs[id]
@@ -155,6 +407,7 @@ func (r *Runner) lastJob() *Job {
 	if r == nil {
 		return nil
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	var last *Job
@@ -166,25 +419,71 @@ func (r *Runner) lastJob() *Job {
 	return last
 }
 
+// previousJob returns the job started before the latest one.
+func (r *Runner) previousJob() *Job {
+	jobs := r.ListJobs()
+	if len(jobs) < 2 {
+		return nil
+	}
+	return jobs[len(jobs)-2]
+}
+
 func (r *Runner) addAPID(pid int) {
 	if r == nil || r.Env == nil {
 		return
 	}
+	env := r.Env
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	vals := append([]string{}, r.Env.Get("apid")...)
+	vals := append([]string{}, env.Get("apid")...)
 	vals = append(vals, strconv.Itoa(pid))
-	r.Env.Set("apid", vals)
+	env.Set("apid", vals)
 }
 
-func (r *Runner) removeAPID(pid int) {
+// apidExited notes that pid, listed in $apid in env, has exited. The
+// goroutine waiting for it cannot change env while the runner using env
+// reads it, so that runner drops the pid in pruneAPID.
+func (r *Runner) apidExited(env *Env, pid int) {
+	if r == nil || env == nil {
+		return
+	}
+	r = r.shell()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.apidEnded == nil {
+		r.apidEnded = make(map[*Env][]int)
+	}
+	r.apidEnded[env] = append(r.apidEnded[env], pid)
+}
+
+// pruneAPID drops the pids that have exited from $apid in r.Env.
+func (r *Runner) pruneAPID() {
 	if r == nil || r.Env == nil {
 		return
 	}
+	env := r.Env
+	sh := r.shell()
+	sh.mu.Lock()
+	pids := sh.apidEnded[env]
+	delete(sh.apidEnded, env)
+	sh.mu.Unlock()
+	for _, pid := range pids {
+		r.removeAPID(env, pid)
+	}
+}
+
+// removeAPID drops pid from $apid in env, the environment of the command
+// that started it in the background.
+func (r *Runner) removeAPID(env *Env, pid int) {
+	if r == nil || env == nil {
+		return
+	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	val := strconv.Itoa(pid)
-	vals := r.Env.Get("apid")
+	vals := env.Get("apid")
 	if len(vals) == 0 {
 		return
 	}
@@ -195,10 +494,10 @@ func (r *Runner) removeAPID(pid int) {
 		}
 	}
 	if len(out) == 0 {
-		r.Env.Unset("apid")
+		env.Unset("apid")
 		return
 	}
-	r.Env.Set("apid", out)
+	env.Set("apid", out)
 }
 
 func formatJobs(jobs []*Job) string {
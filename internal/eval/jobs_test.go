@@ -1,6 +1,18 @@
 package eval
 
-import "testing"
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"grc/internal/parse"
+)
 
 func TestAPIDAppendRemove(t *testing.T) {
 	env := NewEnv(nil)
@@ -11,14 +23,231 @@ func TestAPIDAppendRemove(t *testing.T) {
 	if len(vals) != 2 || vals[0] != "123" || vals[1] != "456" {
 		t.Fatalf("unexpected apid: %v", vals)
 	}
-	r.removeAPID(123)
+	r.removeAPID(r.Env, 123)
 	vals = env.Get("apid")
 	if len(vals) != 1 || vals[0] != "456" {
 		t.Fatalf("unexpected apid after remove: %v", vals)
 	}
-	r.removeAPID(456)
+	r.removeAPID(r.Env, 456)
 	vals = env.Get("apid")
 	if len(vals) != 0 {
 		t.Fatalf("expected apid unset, got %v", vals)
 	}
 }
+
+func TestAPIDDroppedBeforeNextCommand(t *testing.T) {
+	if !haveCmd(t, "true") {
+		t.Skip("true not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	runJobInput(t, r, "true &\n")
+	for _, job := range r.ListJobs() {
+		r.waitJob(job)
+	}
+	if vals := r.Env.Get("apid"); len(vals) != 1 {
+		t.Fatalf("expected the pid to stay until the next command, got %v", vals)
+	}
+	runJobInput(t, r, "x=1\n")
+	if vals := r.Env.Get("apid"); len(vals) != 0 {
+		t.Fatalf("expected apid unset, got %v", vals)
+	}
+}
+
+func runJobInput(t *testing.T, r *Runner, input string) Result {
+	t.Helper()
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	return r.RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+}
+
+func TestForegroundStop(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	r := &Runner{Env: NewEnv(nil), JobControl: true}
+	res := runJobInput(t, r, "sh -c 'kill -STOP $$; exit 3'\n")
+	if res.List.String() != "sigstop" {
+		t.Fatalf("unexpected status: %q", res.List)
+	}
+	jobs := r.ListJobs()
+	if len(jobs) != 1 || jobs[0].State != "stopped" {
+		t.Fatalf("expected one stopped job, got %v", jobs)
+	}
+	if status := builtinFG(nil, io.Discard, io.Discard, []string{"fg"}, r); status != 3 {
+		t.Fatalf("unexpected fg status: %d", status)
+	}
+	if len(r.ListJobs()) != 0 {
+		t.Fatalf("expected the job to be removed")
+	}
+}
+
+func TestBackgroundStopContinue(t *testing.T) {
+	if !haveCmd(t, "sleep") {
+		t.Skip("sleep not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	runJobInput(t, r, "sleep 5 &\n")
+	job := r.lastJob()
+	if job == nil {
+		t.Fatalf("expected a job")
+	}
+	_ = unix.Kill(-job.Pgid, unix.SIGSTOP)
+	if state := r.waitJobState(job, "running"); state != "stopped" {
+		t.Fatalf("unexpected state after SIGSTOP: %s", state)
+	}
+	builtinBG(nil, io.Discard, io.Discard, []string{"bg"}, r)
+	if state := r.waitJobState(job, "stopped"); state != "running" {
+		t.Fatalf("unexpected state after bg: %s", state)
+	}
+	_ = unix.Kill(-job.Pgid, unix.SIGKILL)
+	if exit := r.waitJob(job); exit != 128+int(unix.SIGKILL) {
+		t.Fatalf("unexpected exit: %d", exit)
+	}
+	if job.status.String() != "sigkill" {
+		t.Fatalf("unexpected status: %q", job.status)
+	}
+}
+
+// openPty returns the terminal side of a new pseudo-terminal, or nil when
+// the system has none.
+func openPty(t *testing.T) *os.File {
+	t.Helper()
+	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil
+	}
+	t.Cleanup(func() { ptm.Close() })
+	if err := unix.IoctlSetPointerInt(int(ptm.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		return nil
+	}
+	n, err := unix.IoctlGetInt(int(ptm.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		return nil
+	}
+	pts, err := os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil
+	}
+	t.Cleanup(func() { pts.Close() })
+	return pts
+}
+
+func TestStoppedJobTerminalModes(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "stty") {
+		t.Skip("sh or stty not available")
+	}
+	pts := openPty(t)
+	if pts == nil {
+		t.Skip("no pseudo-terminal")
+	}
+	r := &Runner{Env: NewEnv(nil), JobControl: true, Interactive: true, TTYFD: int(pts.Fd())}
+	r.SaveTerminalModes()
+	canonical := func() bool {
+		modes, err := unix.IoctlGetTermios(r.TTYFD, unix.TCGETS)
+		if err != nil {
+			t.Fatalf("tcgetattr: %v", err)
+		}
+		return modes.Lflag&unix.ICANON != 0
+	}
+	if !canonical() {
+		t.Skip("terminal does not start in canonical mode")
+	}
+
+	ast, err := parse.ParseAll(strings.NewReader("sh -c 'stty raw; kill -STOP $$; stty -a >&2'\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var errOut bytes.Buffer
+	res := r.RunPlan(plan, pts, io.Discard, &errOut)
+	if res.List.String() != "sigstop" {
+		t.Fatalf("unexpected status: %q", res.List)
+	}
+	if !canonical() {
+		t.Fatalf("expected the shell's modes back after the stop")
+	}
+	job := r.lastJob()
+	if job == nil || job.modes == nil || job.modes.Lflag&unix.ICANON != 0 {
+		t.Fatalf("expected the job's raw modes to be saved")
+	}
+	if status := builtinFG(nil, io.Discard, io.Discard, []string{"fg"}, r); status != 0 {
+		t.Fatalf("unexpected fg status: %d", status)
+	}
+	if !strings.Contains(errOut.String(), "-icanon") {
+		t.Fatalf("expected the job to resume in raw mode: %q", errOut.String())
+	}
+	if !canonical() {
+		t.Fatalf("expected the shell's modes back after fg")
+	}
+}
+
+func TestNotifyJobs(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	runJobInput(t, r, "sh -c 'exit 3' &\nsh -c 'kill $$' &\ntrue &\n")
+	for _, job := range r.ListJobs() {
+		r.waitJob(job)
+	}
+
+	var out bytes.Buffer
+	r.Env.Set("notify", []string{"off"})
+	r.NotifyJobs(&out)
+	if out.Len() != 0 || len(r.ListJobs()) != 3 {
+		t.Fatalf("expected no notifications with notify=off: %q", out.String())
+	}
+	r.Env.Unset("notify")
+	r.NotifyJobs(&out)
+	want := "[1] exit 3  sh -c exit 3\n[2] sigterm  sh -c kill $$\n[3] done  true\n"
+	if out.String() != want {
+		t.Fatalf("unexpected notifications: %q", out.String())
+	}
+	if len(r.ListJobs()) != 0 {
+		t.Fatalf("expected reported jobs to be pruned")
+	}
+
+	out.Reset()
+	r.Env.Set("notify", []string{"immediate"})
+	r.NotifyJobs(&out)
+	runJobInput(t, r, "true &\n")
+	var got string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		r.mu.Lock()
+		got = out.String()
+		r.mu.Unlock()
+		if got != "" {
+			break
+		}
+	}
+	if got != "\n[4] done  true\n" {
+		t.Fatalf("unexpected immediate notification: %q", got)
+	}
+	if len(r.ListJobs()) != 0 {
+		t.Fatalf("expected the job to be dropped once reported")
+	}
+}
+
+func TestWaitStatus(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	runJobInput(t, r, "sh -c 'kill $$' &\nwait $apid\n")
+	if got := r.Env.Get("status"); len(got) != 1 || got[0] != "sigterm" {
+		t.Fatalf("unexpected status: %q", got)
+	}
+	runJobInput(t, r, "sh -c 'exit 2' | sh -c 'exit 3' &\nwait\n")
+	if got := r.Env.Get("status"); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Fatalf("unexpected pipeline status: %q", got)
+	}
+}
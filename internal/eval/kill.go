@@ -0,0 +1,182 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// builtinKill sends a signal to jobs and processes:
+//
+//	kill [-sig | -s sig] [--] target...
+//	kill -l [sig...]
+//
+// A signal is a name, with or without SIG and in any case, or a number;
+// the default is TERM. A target is a job (%1, %+, %-), a process id, or a
+// process group as -pgid. Jobs are signalled as a whole process group.
+func builtinKill(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	usage := func() int {
+		fmt.Fprintln(stderr, "usage: kill [-sig | -s sig] target... | kill -l [sig...]")
+		return 1
+	}
+	if len(args) < 2 {
+		return usage()
+	}
+	sig := unix.SIGTERM
+	rest := args[1:]
+	switch a := rest[0]; {
+	case a == "-l":
+		return listSignals(stdout, stderr, rest[1:])
+	case a == "-s":
+		if len(rest) < 2 {
+			return usage()
+		}
+		s, ok := parseSignal(rest[1])
+		if !ok {
+			fmt.Fprintf(stderr, "kill: bad signal %s\n", rest[1])
+			return 1
+		}
+		sig = s
+		rest = rest[2:]
+	case a == "--":
+		rest = rest[1:]
+	case strings.HasPrefix(a, "-"):
+		s, ok := parseSignal(a[1:])
+		if !ok {
+			fmt.Fprintf(stderr, "kill: bad signal %s\n", a[1:])
+			return 1
+		}
+		sig = s
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return usage()
+	}
+	status := 0
+	for _, target := range rest {
+		if err := killTarget(target, sig, r); err != nil {
+			fmt.Fprintf(stderr, "kill: %s: %v\n", target, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func killTarget(target string, sig syscall.Signal, r *Runner) error {
+	if strings.HasPrefix(target, "%") {
+		job, err := resolveJob([]string{"kill", target}, r)
+		if err != nil {
+			return err
+		}
+		return r.signalJob(job, sig)
+	}
+	pid, err := strconv.Atoi(target)
+	if err != nil || pid == 0 || pid == -1 {
+		return fmt.Errorf("bad process id")
+	}
+	// A pid or process group belonging to a job is signalled through it,
+	// so that the job's state follows.
+	if pid < 0 {
+		if job := r.findJobByPgid(-pid); job != nil {
+			return r.signalJob(job, sig)
+		}
+	} else if job := r.findJobByPID(pid); job != nil {
+		return r.signalJobPID(job, pid, sig)
+	}
+	return unix.Kill(pid, sig)
+}
+
+// signalJob sends sig to the process group of job and records the state
+// the signal puts it in. A stopped job is continued after any other
+// signal so that it can act on it. The shell's lock is held throughout,
+// as the goroutine reaping the job changes its state under it.
+func (r *Runner) signalJob(job *Job, sig syscall.Signal) error {
+	return r.signalJobPID(job, 0, sig)
+}
+
+// signalJobPID is signalJob for the single process pid of job, or for
+// all of it when pid is 0.
+func (r *Runner) signalJobPID(job *Job, pid int, sig syscall.Signal) error {
+	send := func(sig syscall.Signal) error {
+		if pid != 0 {
+			return unix.Kill(pid, sig)
+		}
+		return signalGroup(job.Pgid, job.procs, sig)
+	}
+	sh := r.shell()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if job.State == "done" {
+		return fmt.Errorf("job has finished")
+	}
+	if err := send(sig); err != nil {
+		return err
+	}
+	switch sig {
+	case unix.SIGSTOP, unix.SIGTSTP, unix.SIGTTIN, unix.SIGTTOU:
+		sh.setJobStateLocked(job, "stopped")
+	case unix.SIGCONT:
+		sh.setJobStateLocked(job, "running")
+	default:
+		if job.State == "stopped" {
+			sh.setJobStateLocked(job, "running")
+			_ = send(unix.SIGCONT)
+		}
+	}
+	return nil
+}
+
+// parseSignal accepts a signal number or a name such as TERM, SIGTERM or
+// sigterm.
+func parseSignal(s string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || unix.SignalName(syscall.Signal(n)) == "" {
+			return 0, false
+		}
+		return syscall.Signal(n), true
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	return sig, sig != 0
+}
+
+// listSignals prints every signal name, or converts each argument between
+// a name and its number.
+func listSignals(stdout, stderr io.Writer, args []string) int {
+	if len(args) == 0 {
+		var names []string
+		for n := 1; n < 65; n++ {
+			if name := unix.SignalName(syscall.Signal(n)); name != "" {
+				names = append(names, strings.TrimPrefix(name, "SIG"))
+			}
+		}
+		fmt.Fprintln(stdout, strings.Join(names, " "))
+		return 0
+	}
+	status := 0
+	for _, a := range args {
+		sig, ok := parseSignal(a)
+		if !ok {
+			fmt.Fprintf(stderr, "kill: bad signal %s\n", a)
+			status = 1
+			continue
+		}
+		if _, err := strconv.Atoi(a); err == nil {
+			fmt.Fprintln(stdout, strings.TrimPrefix(unix.SignalName(sig), "SIG"))
+		} else {
+			fmt.Fprintln(stdout, int(sig))
+		}
+	}
+	return status
+}
@@ -0,0 +1,107 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]unix.Signal{
+		"TERM":    unix.SIGTERM,
+		"SIGTERM": unix.SIGTERM,
+		"sigint":  unix.SIGINT,
+		"kill":    unix.SIGKILL,
+		"9":       unix.SIGKILL,
+	}
+	for in, want := range cases {
+		if got, ok := parseSignal(in); !ok || got != want {
+			t.Fatalf("parseSignal(%q) = %v, %v", in, got, ok)
+		}
+	}
+	for _, in := range []string{"", "nosuch", "0", "-3"} {
+		if _, ok := parseSignal(in); ok {
+			t.Fatalf("parseSignal(%q) accepted a bad signal", in)
+		}
+	}
+}
+
+func TestKillList(t *testing.T) {
+	var out bytes.Buffer
+	if status := builtinKill(nil, &out, io.Discard, []string{"kill", "-l", "9", "term"}, nil); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if out.String() != "KILL\n15\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestKillJobs(t *testing.T) {
+	if !haveCmd(t, "sleep") {
+		t.Skip("sleep not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	runJobInput(t, r, "sleep 5 | sleep 5 &\nsleep 5 &\n")
+	jobs := r.ListJobs()
+	if len(jobs) != 2 {
+		t.Fatalf("expected two jobs, got %d", len(jobs))
+	}
+	first, second := jobs[0], jobs[1]
+
+	if status := builtinKill(nil, io.Discard, io.Discard, []string{"kill", "-STOP", "%-"}, r); status != 0 {
+		t.Fatalf("kill -STOP failed: %d", status)
+	}
+	if first.State != "stopped" {
+		t.Fatalf("expected %%- to be stopped, got %s", first.State)
+	}
+	builtinKill(nil, io.Discard, io.Discard, []string{"kill", "%1"}, r)
+	if exit := r.waitJob(first); exit != 128+int(unix.SIGTERM) {
+		t.Fatalf("unexpected exit of the stopped pipeline: %d", exit)
+	}
+	if first.status.String() != "sigterm|sigterm" {
+		t.Fatalf("expected every stage to be signalled, got %q", first.status)
+	}
+
+	pgid := "-" + strconv.Itoa(second.Pgid)
+	if status := builtinKill(nil, io.Discard, io.Discard, []string{"kill", "-s", "kill", "--", pgid}, r); status != 0 {
+		t.Fatalf("kill by process group failed: %d", status)
+	}
+	if exit := r.waitJob(second); exit != 128+int(unix.SIGKILL) {
+		t.Fatalf("unexpected exit: %d", exit)
+	}
+
+	var errOut bytes.Buffer
+	if status := builtinKill(nil, io.Discard, &errOut, []string{"kill", "%9"}, r); status != 1 || errOut.Len() == 0 {
+		t.Fatalf("expected an error for a missing job: %d %q", status, errOut.String())
+	}
+}
+
+func TestKillJobByPID(t *testing.T) {
+	if !haveCmd(t, "sleep") {
+		t.Skip("sleep not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	runJobInput(t, r, "sleep 5 &\n")
+	jobs := r.ListJobs()
+	if len(jobs) != 1 || len(jobs[0].Pids) != 1 {
+		t.Fatalf("expected one single-process job, got %d", len(jobs))
+	}
+	job := jobs[0]
+	pid := strconv.Itoa(job.Pids[0])
+
+	if status := builtinKill(nil, io.Discard, io.Discard, []string{"kill", "-STOP", pid}, r); status != 0 {
+		t.Fatalf("kill -STOP failed: %d", status)
+	}
+	if job.State != "stopped" {
+		t.Fatalf("expected the job to be stopped, got %s", job.State)
+	}
+	if status := builtinKill(nil, io.Discard, io.Discard, []string{"kill", pid}, r); status != 0 {
+		t.Fatalf("kill failed: %d", status)
+	}
+	if exit := r.waitJob(job); exit != 128+int(unix.SIGTERM) {
+		t.Fatalf("unexpected exit: %d", exit)
+	}
+}
@@ -0,0 +1,424 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rlimit names a resource limit the limit builtin can read and set. Sizes
+// are in bytes and accept k, m and g suffixes; cpu is in seconds. opt is
+// the limit's ulimit option, whose values count units of unit bytes.
+type rlimit struct {
+	name     string
+	resource int
+	size     bool
+	opt      byte
+	unit     uint64
+	what     string
+}
+
+var rlimits = []rlimit{
+	{name: "cpu", resource: unix.RLIMIT_CPU, opt: 't', unit: 1, what: "time(seconds)"},
+	{name: "fsize", resource: unix.RLIMIT_FSIZE, size: true, opt: 'f', unit: 512, what: "file(blocks)"},
+	{name: "nofile", resource: unix.RLIMIT_NOFILE, opt: 'n', unit: 1, what: "nofiles"},
+	{name: "nproc", resource: unix.RLIMIT_NPROC, opt: 'u', unit: 1, what: "processes"},
+	{name: "core", resource: unix.RLIMIT_CORE, size: true, opt: 'c', unit: 512, what: "coredump(blocks)"},
+	{name: "as", resource: unix.RLIMIT_AS, size: true, opt: 'v', unit: 1024, what: "vmemory(kbytes)"},
+	{name: "stack", resource: unix.RLIMIT_STACK, size: true, opt: 's', unit: 1024, what: "stack(kbytes)"},
+}
+
+func lookupRlimit(name string) (rlimit, bool) {
+	for _, l := range rlimits {
+		if l.name == name {
+			return l, true
+		}
+	}
+	return rlimit{}, false
+}
+
+// builtinLimit prints or sets resource limits of the shell, which every
+// command it starts inherits:
+//
+//	limit [-h] [resource [value]]
+//
+// The soft limit is used unless -h asks for the hard one.
+func builtinLimit(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	_ = r
+	hard := false
+	rest := args[1:]
+	if len(rest) > 0 && rest[0] == "-h" {
+		hard = true
+		rest = rest[1:]
+	}
+	if len(rest) > 2 || len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+		fmt.Fprintln(stderr, "usage: limit [-h] [resource [value]]")
+		return 1
+	}
+	if len(rest) == 0 {
+		status := 0
+		for _, l := range rlimits {
+			if err := printRlimit(stdout, l, hard); err != nil {
+				fmt.Fprintf(stderr, "limit: %s: %v\n", l.name, err)
+				status = 1
+			}
+		}
+		return status
+	}
+	l, ok := lookupRlimit(rest[0])
+	if !ok {
+		fmt.Fprintf(stderr, "limit: no such resource: %s\n", rest[0])
+		return 1
+	}
+	if len(rest) == 1 {
+		if err := printRlimit(stdout, l, hard); err != nil {
+			fmt.Fprintf(stderr, "limit: %s: %v\n", l.name, err)
+			return 1
+		}
+		return 0
+	}
+	val, err := parseRlimit(rest[1], l.size)
+	if err == nil {
+		err = setRlimit(l, val, hard, !hard)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "limit: %s: %v\n", l.name, err)
+		return 1
+	}
+	return 0
+}
+
+// builtinUlimit is the POSIX form of limit:
+//
+//	ulimit [-H | -S] [-a | -c | -f | -n | -s | -t | -u | -v] [value]
+//
+// -f is the default. Core and file sizes count 512-byte blocks, stack and
+// virtual memory sizes kilobytes. A new value sets both the soft and the
+// hard limit unless -S or -H picks one; printing shows the soft limit
+// unless -H is given.
+func builtinUlimit(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	_ = r
+	usage := func() int {
+		fmt.Fprintln(stderr, "usage: ulimit [-H | -S] [-a | -c | -f | -n | -s | -t | -u | -v] [value]")
+		return 1
+	}
+	soft, hard, all := false, false, false
+	var opt byte
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-") && len(args[i]) > 1; i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		for _, c := range []byte(args[i][1:]) {
+			switch c {
+			case 'H':
+				hard = true
+			case 'S':
+				soft = true
+			case 'a':
+				all = true
+			case 'c', 'f', 'n', 's', 't', 'u', 'v':
+				if opt != 0 && opt != c {
+					return usage()
+				}
+				opt = c
+			default:
+				return usage()
+			}
+		}
+	}
+	rest := args[i:]
+	if len(rest) > 1 || all && (opt != 0 || len(rest) > 0) {
+		return usage()
+	}
+	if all {
+		status := 0
+		for _, l := range rlimits {
+			val, err := getRlimit(l, hard && !soft)
+			if err != nil {
+				fmt.Fprintf(stderr, "ulimit: %s: %v\n", l.what, err)
+				status = 1
+				continue
+			}
+			fmt.Fprintf(stdout, "-%c: %-20s %s\n", l.opt, l.what, formatUlimit(val, l.unit))
+		}
+		return status
+	}
+	if opt == 0 {
+		opt = 'f'
+	}
+	var l rlimit
+	for _, cand := range rlimits {
+		if cand.opt == opt {
+			l = cand
+		}
+	}
+	if len(rest) == 0 {
+		val, err := getRlimit(l, hard && !soft)
+		if err != nil {
+			fmt.Fprintf(stderr, "ulimit: %s: %v\n", l.what, err)
+			return 1
+		}
+		fmt.Fprintln(stdout, formatUlimit(val, l.unit))
+		return 0
+	}
+	val, err := parseUlimit(rest[0], l.unit)
+	if err == nil {
+		if !soft && !hard {
+			soft, hard = true, true
+		}
+		err = setRlimit(l, val, hard, soft)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "ulimit: %s: %v\n", l.what, err)
+		return 1
+	}
+	return 0
+}
+
+func getRlimit(l rlimit, hard bool) (uint64, error) {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(l.resource, &lim); err != nil {
+		return 0, err
+	}
+	if hard {
+		return lim.Max, nil
+	}
+	return lim.Cur, nil
+}
+
+// setRlimit sets the hard or soft limit of l, or both, to val. A hard
+// limit below the soft one lowers the soft limit with it.
+func setRlimit(l rlimit, val uint64, hard, soft bool) error {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(l.resource, &lim); err != nil {
+		return err
+	}
+	if hard {
+		lim.Max = val
+		if lim.Cur > val {
+			lim.Cur = val
+		}
+	}
+	if soft {
+		lim.Cur = val
+	}
+	return unix.Setrlimit(l.resource, &lim)
+}
+
+func printRlimit(w io.Writer, l rlimit, hard bool) error {
+	val, err := getRlimit(l, hard)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s\t%s\n", l.name, formatRlimit(val))
+	return nil
+}
+
+func formatRlimit(v uint64) string {
+	if v == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// formatUlimit prints v in units of unit bytes, rounding down.
+func formatUlimit(v, unit uint64) string {
+	if v == unix.RLIM_INFINITY {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v/unit, 10)
+}
+
+// parseUlimit reads a ulimit value, a count of unit bytes or unlimited.
+func parseUlimit(s string, unit uint64) (uint64, error) {
+	if s == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad limit %q", s)
+	}
+	if v > unix.RLIM_INFINITY/unit {
+		return 0, fmt.Errorf("limit %q out of range", s)
+	}
+	return v * unit, nil
+}
+
+func parseRlimit(s string, size bool) (uint64, error) {
+	if s == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	mult := uint64(1)
+	if size && s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad limit %q", s)
+	}
+	if v > unix.RLIM_INFINITY/mult {
+		return 0, fmt.Errorf("limit %q out of range", s)
+	}
+	return v * mult, nil
+}
+
+// builtinUmask prints the file creation mask in octal, or with -S in
+// symbolic form, or sets it from an octal or symbolic mode such as 022 or
+// u=rwx,g=rx,o=.
+func builtinUmask(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+	_ = stdin
+	_ = r
+	symbolic := false
+	rest := args[1:]
+	if len(rest) > 0 && rest[0] == "-S" {
+		symbolic = true
+		rest = rest[1:]
+	}
+	if len(rest) > 1 {
+		fmt.Fprintln(stderr, "usage: umask [-S] [mode]")
+		return 1
+	}
+	cur := currentUmask()
+	if len(rest) == 0 {
+		if symbolic {
+			fmt.Fprintln(stdout, formatUmaskSymbolic(cur))
+		} else {
+			fmt.Fprintf(stdout, "%04o\n", cur)
+		}
+		return 0
+	}
+	mask, err := parseUmask(rest[0], cur)
+	if err != nil {
+		fmt.Fprintf(stderr, "umask: %v\n", err)
+		return 1
+	}
+	syscall.Umask(int(mask))
+	return 0
+}
+
+// currentUmask reads the mask from the Umask line of /proc/self/status.
+// Setting a mask to learn the old one would leave files created by other
+// goroutines meanwhile with the wrong permissions; without /proc that is
+// the fallback.
+func currentUmask() uint32 {
+	if data, err := os.ReadFile("/proc/self/status"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(line, "Umask:"); ok {
+				if m, err := strconv.ParseUint(strings.TrimSpace(v), 8, 32); err == nil {
+					return uint32(m)
+				}
+			}
+		}
+	}
+	old := syscall.Umask(0)
+	syscall.Umask(old)
+	return uint32(old)
+}
+
+// parseUmask returns the mask for mode, which is an octal number or a
+// comma-separated list of chmod-style clauses applied to the permissions
+// cur leaves open.
+func parseUmask(mode string, cur uint32) (uint32, error) {
+	if mode == "" {
+		return 0, errors.New("empty mode")
+	}
+	if mode[0] >= '0' && mode[0] <= '9' {
+		v, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || v > 0o777 {
+			return 0, fmt.Errorf("bad mode %q", mode)
+		}
+		return uint32(v), nil
+	}
+	perm := ^cur & 0o777
+	for _, clause := range strings.Split(mode, ",") {
+		var who uint32
+		i := 0
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 0o700
+			case 'g':
+				who |= 0o070
+			case 'o':
+				who |= 0o007
+			case 'a':
+				who |= 0o777
+			}
+		}
+		if who == 0 {
+			who = 0o777
+		}
+		if i == len(clause) {
+			return 0, fmt.Errorf("bad mode %q", mode)
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if op != '=' && op != '+' && op != '-' {
+				return 0, fmt.Errorf("bad mode %q", mode)
+			}
+			i++
+			var bits uint32
+			for ; i < len(clause) && strings.IndexByte("rwx", clause[i]) >= 0; i++ {
+				switch clause[i] {
+				case 'r':
+					bits |= 0o444
+				case 'w':
+					bits |= 0o222
+				case 'x':
+					bits |= 0o111
+				}
+			}
+			bits &= who
+			switch op {
+			case '=':
+				perm = perm&^who | bits
+			case '+':
+				perm |= bits
+			case '-':
+				perm &^= bits
+			}
+		}
+	}
+	return ^perm & 0o777, nil
+}
+
+func formatUmaskSymbolic(mask uint32) string {
+	perm := ^mask & 0o777
+	parts := make([]string, 0, 3)
+	for i, who := range []string{"u", "g", "o"} {
+		shift := uint(6 - 3*i)
+		bits := perm >> shift & 7
+		s := who + "="
+		if bits&4 != 0 {
+			s += "r"
+		}
+		if bits&2 != 0 {
+			s += "w"
+		}
+		if bits&1 != 0 {
+			s += "x"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ",")
+}
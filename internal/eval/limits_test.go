@@ -0,0 +1,123 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseUmask(t *testing.T) {
+	cases := []struct {
+		mode string
+		cur  uint32
+		want uint32
+	}{
+		{"027", 0o022, 0o027},
+		{"u=rwx,g=rx,o=", 0o022, 0o027},
+		{"g-w", 0o002, 0o022},
+		{"o+r", 0o077, 0o073},
+		{"a=r", 0o022, 0o333},
+		{"=rx", 0o000, 0o222},
+		{"u+x-w", 0o000, 0o200},
+	}
+	for _, c := range cases {
+		got, err := parseUmask(c.mode, c.cur)
+		if err != nil {
+			t.Fatalf("parseUmask(%q) returned error: %v", c.mode, err)
+		}
+		if got != c.want {
+			t.Fatalf("parseUmask(%q, %04o) = %04o, want %04o", c.mode, c.cur, got, c.want)
+		}
+	}
+	for _, mode := range []string{"", "8", "1777", "u", "u*r", "z=r"} {
+		if _, err := parseUmask(mode, 0o022); err == nil {
+			t.Fatalf("parseUmask(%q) should fail", mode)
+		}
+	}
+}
+
+func TestBuiltinUmask(t *testing.T) {
+	old := syscall.Umask(0o022)
+	defer syscall.Umask(old)
+	var out bytes.Buffer
+	if status := builtinUmask(nil, &out, io.Discard, []string{"umask", "g-rx,o="}, nil); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	builtinUmask(nil, &out, io.Discard, []string{"umask"}, nil)
+	builtinUmask(nil, &out, io.Discard, []string{"umask", "-S"}, nil)
+	if out.String() != "0077\nu=rwx,g=,o=\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestBuiltinLimit(t *testing.T) {
+	var orig unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_CORE, &orig); err != nil {
+		t.Skipf("getrlimit: %v", err)
+	}
+	defer unix.Setrlimit(unix.RLIMIT_CORE, &orig)
+	if orig.Max != unix.RLIM_INFINITY && orig.Max < 1<<20 {
+		t.Skip("hard core limit too low")
+	}
+
+	var out bytes.Buffer
+	if status := builtinLimit(nil, &out, io.Discard, []string{"limit", "core", "1m"}, nil); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	builtinLimit(nil, &out, io.Discard, []string{"limit", "core"}, nil)
+	if out.String() != "core\t1048576\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	var errOut bytes.Buffer
+	if status := builtinLimit(nil, &out, &errOut, []string{"limit", "bogus"}, nil); status == 0 {
+		t.Fatalf("expected failure for an unknown resource")
+	}
+	if !strings.Contains(errOut.String(), "bogus") {
+		t.Fatalf("unexpected stderr: %q", errOut.String())
+	}
+}
+
+func TestBuiltinUlimit(t *testing.T) {
+	var orig unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_CORE, &orig); err != nil {
+		t.Skipf("getrlimit: %v", err)
+	}
+	defer unix.Setrlimit(unix.RLIMIT_CORE, &orig)
+	if orig.Max != unix.RLIM_INFINITY && orig.Max < 1<<20 {
+		t.Skip("hard core limit too low")
+	}
+
+	var out bytes.Buffer
+	if status := builtinUlimit(nil, &out, io.Discard, []string{"ulimit", "-S", "-c", "2048"}, nil); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	builtinUlimit(nil, &out, io.Discard, []string{"ulimit", "-c"}, nil)
+	builtinLimit(nil, &out, io.Discard, []string{"limit", "core"}, nil)
+	if out.String() != "2048\ncore\t1048576\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	var nofile unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &nofile); err != nil {
+		t.Fatalf("getrlimit: %v", err)
+	}
+	cur := strconv.FormatUint(nofile.Cur, 10)
+	out.Reset()
+	if status := builtinUlimit(nil, &out, io.Discard, []string{"ulimit", "-Sn", cur}, nil); status != 0 {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	builtinUlimit(nil, &out, io.Discard, []string{"ulimit", "-n"}, nil)
+	if out.String() != cur+"\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	for _, args := range [][]string{{"ulimit", "-x"}, {"ulimit", "-a", "-n"}, {"ulimit", "-c", "-n"}, {"ulimit", "-n", "lots"}} {
+		var errOut bytes.Buffer
+		if status := builtinUlimit(nil, io.Discard, &errOut, args, nil); status == 0 || errOut.Len() == 0 {
+			t.Fatalf("expected %q to fail", args)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// pipeStage is one command of a pipeline and the descriptors it runs with.
+type pipeStage struct {
+	plan     *ExecPlan
+	io       stageIO
+	ends     []*os.File
+	prep     stagePrep
+	prepared bool
+	external bool
+	done     bool
+	proc     Process
+	cleanup  func()
+	status   Status
+	span     *traceSpan
+	runner   *Runner
+}
+
+// runPipeline runs the whole PipeTo chain starting at head. Every stage is
+// connected to the next by a kernel pipe. External stages are started
+// together in one process group led by the first of them; builtin,
+// function and compound stages run in goroutines on the same pipes, each
+// with a stage runner of its own. The status has one entry per stage.
+func (r *Runner) runPipeline(head *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, fds fdTable, background bool) Status {
+	stdout, stderr = shareWriters(stdout, stderr)
+	var stages []*pipeStage
+	for p := head; p != nil; p = p.PipeTo {
+		st := &pipeStage{plan: p, io: newStageIO(stdin, stdout, stderr, fds)}
+		st.span = r.traceStage(p, len(stages)+1, stderr)
+		stages = append(stages, st)
+	}
+	if err := connectStages(stages); err != nil {
+		fmt.Fprintf(stderr, "rc: %v\n", err)
+		return statusFalse
+	}
+
+	// Expand every command before anything runs, so that the external
+	// stages are known and can be started together.
+	for _, st := range stages {
+		st.runner = r.stageRunner(st.io.fds)
+		if st.plan.Kind != PlanCmd {
+			continue
+		}
+		prep, err := st.runner.prepareCommand(st.plan)
+		if err != nil {
+			st.finish(statusFalse)
+			continue
+		}
+		st.prep = prep
+		st.prepared = true
+		st.external = st.runner.isExternal(prep)
+		st.span.setArgv(prep.argv)
+	}
+
+	pgid := 0
+	var pids []int
+	for _, st := range stages {
+		if st.done || !st.external {
+			continue
+		}
+		r.tracef("+ %s\n", strings.Join(st.prep.argv, " "))
+		c, cleanup, err := buildCommand(st.prep.argv, st.plan, r, st.prep.env, st.io.stdin, st.io.stdout, st.io.stderr, st.io.fds)
+		if err != nil {
+			fmt.Fprintf(st.io.stderr, "rc: %v\n", err)
+			st.finish(statusFalse)
+			continue
+		}
+		ownGroup := background || r.JobControl || r.cancellable()
+		c.Setpgid, c.Pgid = ownGroup, pgid
+		proc, status := r.startCommand(c, st.io.stderr)
+		if proc == nil {
+			cleanup()
+			st.finish(status)
+			continue
+		}
+		if pgid == 0 && ownGroup {
+			pgid = proc.Pid()
+		}
+		st.proc = proc
+		st.cleanup = cleanup
+		st.span.setProcess(proc.Pid(), commandGroup(c, proc.Pid()))
+		closeFiles(st.ends)
+		st.ends = nil
+		pids = append(pids, proc.Pid())
+	}
+
+	// Internal stages are already asynchronous, so they run as foreground
+	// work of their goroutine rather than as jobs of their own.
+	var wg sync.WaitGroup
+	var ends []*os.File
+	for _, st := range stages {
+		if !st.done && st.proc == nil {
+			ends = append(ends, st.ends...)
+		}
+	}
+	if !background {
+		defer r.closeOnCancel(ends)()
+	}
+	for _, st := range stages {
+		if st.done || st.proc != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(st *pipeStage) {
+			defer wg.Done()
+			if st.prepared {
+				st.finish(st.runner.runCommand(st.plan, st.prep, st.io.stdin, st.io.stdout, st.io.stderr, st.io.fds, false, st.span))
+				return
+			}
+			st.finish(st.runner.runStage(st.plan, st.io.stdin, st.io.stdout, st.io.stderr, st.io.fds, false, st.span))
+		}(st)
+	}
+
+	if background {
+		if len(pids) > 0 {
+			job := r.onBackgroundStart(pgid, pids, pipelineName(stages))
+			for _, st := range stages {
+				if st.proc != nil {
+					job.procs = append(job.procs, st.proc)
+				}
+			}
+			go r.waitJobPids(job, pids)
+		}
+		go func() {
+			wg.Wait()
+			for _, st := range stages {
+				if st.cleanup != nil {
+					st.cleanup()
+				}
+			}
+		}()
+		return statusTrue
+	}
+
+	if r.JobControl && len(pids) > 0 {
+		job := newJob(pgid, pids, pipelineName(stages))
+		var external []*pipeStage
+		for _, st := range stages {
+			if st.proc == nil {
+				continue
+			}
+			external = append(external, st)
+			job.procs = append(job.procs, st.proc)
+			job.cleanup = append(job.cleanup, st.cleanup)
+		}
+		status, stopped := r.runForegroundJob(job, stderr)
+		if stopped {
+			// The stages inside the shell may be blocked on the stopped
+			// processes, so they are left to finish with the job.
+			return status
+		}
+		for i, st := range external {
+			st.finish(Status{status[i]})
+		}
+		wg.Wait()
+		return pipelineStatus(stages)
+	}
+
+	var procs []Process
+	for _, st := range stages {
+		if st.proc != nil {
+			procs = append(procs, st.proc)
+		}
+	}
+	defer r.killOnCancel(pgid, procs)()
+	r.waitForeground(pgid, func() {
+		for _, st := range stages {
+			if st.proc == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(st *pipeStage) {
+				defer wg.Done()
+				status := st.proc.Wait()
+				st.cleanup()
+				st.finish(status)
+			}(st)
+		}
+		wg.Wait()
+	})
+	return pipelineStatus(stages)
+}
+
+// pipelineStatus collects the status of every stage, one entry each.
+func pipelineStatus(stages []*pipeStage) Status {
+	status := make(Status, len(stages))
+	for i, st := range stages {
+		status[i] = st.status.word()
+	}
+	return status
+}
+
+// connectStages creates the pipe between each pair of adjacent stages and
+// places its ends on the descriptors chosen by |[a=b]. A stage reading the
+// pipe on another descriptor keeps the stdin of the pipeline if that is a
+// file. Any other reader is copied into each process by a goroutine of
+// its own, so only the first stage gets it and the others read /dev/null.
+func connectStages(stages []*pipeStage) error {
+	_, shared := stages[0].io.stdin.(*os.File)
+	for i := 0; i+1 < len(stages); i++ {
+		left, right := stages[i], stages[i+1]
+		leftFd, rightFd := pipeFDs(left.plan)
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			closeStages(stages)
+			return err
+		}
+		left.ends = append(left.ends, pw)
+		right.ends = append(right.ends, pr)
+		if err := left.io.place(leftFd, pw); err != nil {
+			closeStages(stages)
+			return err
+		}
+		if err := right.io.place(rightFd, pr); err != nil {
+			closeStages(stages)
+			return err
+		}
+		if rightFd != 0 && !shared {
+			null, err := os.Open(os.DevNull)
+			if err != nil {
+				closeStages(stages)
+				return err
+			}
+			right.ends = append(right.ends, null)
+			right.io.stdin = null
+		}
+	}
+	return nil
+}
+
+func closeStages(stages []*pipeStage) {
+	for _, st := range stages {
+		closeFiles(st.ends)
+		st.ends = nil
+	}
+}
+
+// finish records the status of a stage and releases its pipe ends so the
+// neighbouring stages see EOF or a broken pipe.
+func (st *pipeStage) finish(status Status) {
+	st.status = status
+	st.done = true
+	st.span.end(status)
+	closeFiles(st.ends)
+	st.ends = nil
+}
+
+func pipelineName(stages []*pipeStage) string {
+	names := make([]string, 0, len(stages))
+	for _, st := range stages {
+		if st.prepared && len(st.prep.argv) > 0 {
+			names = append(names, strings.Join(st.prep.argv, " "))
+			continue
+		}
+		names = append(names, "{...}")
+	}
+	return strings.Join(names, " | ")
+}
@@ -0,0 +1,167 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"grc/internal/parse"
+)
+
+func runPipelineInput(t *testing.T, r *Runner, input string) (string, int) {
+	t.Helper()
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	res := r.RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	return out.String(), res.Status
+}
+
+func TestPipelineFourStages(t *testing.T) {
+	if !haveCmd(t, "printf") || !haveCmd(t, "sort") || !haveCmd(t, "tr") || !haveCmd(t, "head") {
+		t.Skip("printf, sort, tr or head not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	out, status := runPipelineInput(t, r, "printf 'b\\nc\\na\\n' | sort | tr a-z A-Z | head -n 2\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if out != "A\nB\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPipelineFunctionStage(t *testing.T) {
+	if !haveCmd(t, "tr") || !haveCmd(t, "wc") {
+		t.Skip("tr or wc not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	out, status := runPipelineInput(t, r, "fn up { tr a-z A-Z }\necho abc | up | wc -c\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if strings.TrimSpace(out) != "4" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPipelineStagesOwnState(t *testing.T) {
+	if !haveCmd(t, "cat") {
+		t.Skip("cat not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	r.SetBuiltin("echo", func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+		_, _ = io.WriteString(stdout, strings.Join(args[1:], " ")+"\n")
+		return 0
+	})
+	out, status := runPipelineInput(t, r, "fn f { x=in; echo $x }\nf | cat\nexit 3 | echo $x | cat\necho after $status\n")
+	if status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if r.ExitRequested() {
+		t.Fatalf("exit in a pipeline stage ended the shell")
+	}
+	if out != "in\n\nafter 3 0 0\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestPipelineStatusList(t *testing.T) {
+	if !haveCmd(t, "true") || !haveCmd(t, "false") {
+		t.Skip("true or false not available")
+	}
+	env := NewEnv(nil)
+	ast, err := parse.ParseAll(strings.NewReader("false | true | false\n"))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	res := (&Runner{Env: env}).RunPlan(plan, strings.NewReader(""), io.Discard, io.Discard)
+	if strings.Join(res.List, " ") != "1 0 1" {
+		t.Fatalf("unexpected status list: %v", res.List)
+	}
+	if got := env.Get("status"); strings.Join(got, " ") != "1 0 1" {
+		t.Fatalf("unexpected $status: %v", got)
+	}
+	if res.Status == 0 {
+		t.Fatalf("expected failing exit code")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	if _, status := runPipelineInput(t, r, "false | true | true\n"); status == 0 {
+		t.Fatalf("expected failure when any stage fails")
+	}
+	if _, status := runPipelineInput(t, r, "true | true\n"); status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+}
+
+func TestPipelineExpandsBuiltinStageOnce(t *testing.T) {
+	if !haveCmd(t, "sh") || !haveCmd(t, "cat") || !haveCmd(t, "true") {
+		t.Skip("sh, cat or true not available")
+	}
+	dir := t.TempDir()
+	count := func(input string) string {
+		countPath := filepath.Join(dir, "count")
+		_ = os.Remove(countPath)
+		r := &Runner{Env: NewEnv(nil)}
+		input = strings.ReplaceAll(input, "COUNT", countPath)
+		if _, status := runPipelineInput(t, r, input); status != 0 {
+			t.Fatalf("expected status 0, got %d", status)
+		}
+		data, err := os.ReadFile(countPath)
+		if err != nil {
+			t.Fatalf("read count: %v", err)
+		}
+		return string(data)
+	}
+	single := count("echo `{sh -c 'echo x >> COUNT'}\n")
+	piped := count("true | echo `{sh -c 'echo x >> COUNT'} | cat\n")
+	if piped != single {
+		t.Fatalf("pipeline stage expanded %q, single command %q", piped, single)
+	}
+}
+
+func TestPipelineBackgroundSingleJob(t *testing.T) {
+	if !haveCmd(t, "sleep") || !haveCmd(t, "cat") {
+		t.Skip("sleep or cat not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	if _, status := runPipelineInput(t, r, "sleep 1 | cat | cat &\n"); status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	jobs := r.ListJobs()
+	if len(jobs) != 1 {
+		t.Fatalf("expected one job, got %d", len(jobs))
+	}
+	job := jobs[0]
+	if len(job.Pids) != 3 {
+		t.Fatalf("expected 3 pids, got %v", job.Pids)
+	}
+	for _, pid := range job.Pids {
+		pgid, err := unix.Getpgid(pid)
+		if err != nil {
+			t.Fatalf("getpgid %d: %v", pid, err)
+		}
+		if pgid != job.Pgid {
+			t.Fatalf("pid %d in pgid %d, want %d", pid, pgid, job.Pgid)
+		}
+	}
+	if job.Cmd != "sleep 1 | cat | cat" {
+		t.Fatalf("unexpected job command: %q", job.Cmd)
+	}
+	r.waitJob(job)
+}
@@ -306,3 +306,44 @@ func TestPlanOr(t *testing.T) {
 		t.Fatalf("unexpected right argv: %v", plan.IfFail.Argv)
 	}
 }
+
+func TestPlanPipeChain(t *testing.T) {
+	ast, err := parse.Parse(strings.NewReader("a|b|c\n"))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, NewEnv(nil))
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var got []string
+	for p := plan; p != nil; p = p.PipeTo {
+		got = append(got, p.Argv...)
+	}
+	if strings.Join(got, " ") != "a b c" {
+		t.Fatalf("unexpected pipeline stages: %v", got)
+	}
+}
+
+func TestPlanPipeFDs(t *testing.T) {
+	ast, err := parse.Parse(strings.NewReader("a |[2] b |[1=3] c |[0=0] d\n"))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, NewEnv(nil))
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	if l, r := pipeFDs(plan); l != 2 || r != 0 {
+		t.Fatalf("unexpected first pipe fds: %d=%d", l, r)
+	}
+	if plan.PipeTo == nil {
+		t.Fatalf("expected second stage")
+	}
+	if l, r := pipeFDs(plan.PipeTo); l != 1 || r != 3 {
+		t.Fatalf("unexpected second pipe fds: %d=%d", l, r)
+	}
+	if l, r := pipeFDs(plan.PipeTo.PipeTo); l != 0 || r != 0 {
+		t.Fatalf("unexpected third pipe fds: %d=%d", l, r)
+	}
+}
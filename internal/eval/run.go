@@ -1,6 +1,7 @@
 package eval
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 
@@ -17,24 +19,46 @@ import (
 
 // Runner executes execution plans.
 type Runner struct {
-	Env            *Env
-	Builtins       map[string]Builtin
-	Trace          bool
-	TraceWriter    io.Writer
-	Interactive    bool
-	JobControl     bool
-	TTYFD          int
-	ShellPgid      int
-	ForegroundPgid int
-	SelfPath       string
+	Env             *Env
+	Builtins        map[string]Builtin
+	Trace           bool
+	TraceWriter     io.Writer
+	Interactive     bool
+	JobControl      bool
+	TTYFD           int
+	ShellPgid       int
+	ForegroundPgid  int
+	SelfPath        string
+	Executor        Executor      // starts external commands; nil means OSExecutor
+	KillDelay       time.Duration // between SIGTERM and SIGKILL on cancel
+	FS              FS            // files and working directory; nil means an OSFS
+	TraceJSON       io.Writer     // JSON trace events; nil means $tracefile
 	returnRequested bool
-	returnCode      int
+	returnStatus    Status
+	builtinStatus   Status
 	returnDepth     int
-	mu             sync.Mutex
-	Jobs           map[int]*Job
-	nextJobID      int
-	exitRequested  bool
-	exitCode       int
+	fds             fdTable
+	mu              sync.Mutex
+	Jobs            map[int]*Job
+	nextJobID       int
+	exitRequested   bool
+	exitStatus      Status
+	sigc            chan os.Signal
+	sigWatched      []os.Signal
+	inTrap          bool
+	nested          bool
+	exitTrapped     bool
+	flags           map[byte]bool
+	condDepth       int
+	completers      map[string]string
+	shellModes      *unix.Termios
+	notify          string
+	notifyTo        io.Writer
+	apidEnded       map[*Env][]int // exited pids still listed in $apid
+	ctx             context.Context
+	tracer          *tracer
+	traceDepth      int     // of the next JSON trace event
+	parent          *Runner // the shell a stage runner was made for
 }
 
 // ExitRequested reports whether an exit builtin has been invoked.
@@ -50,7 +74,15 @@ func (r *Runner) ExitCode() int {
 	if r == nil {
 		return 0
 	}
-	return r.exitCode
+	return r.exitStatus.Code()
+}
+
+// ExitStatus returns the requested exit status.
+func (r *Runner) ExitStatus() Status {
+	if r == nil {
+		return nil
+	}
+	return r.exitStatus
 }
 
 // Foreground returns the current foreground process group.
@@ -58,18 +90,78 @@ func (r *Runner) Foreground() int {
 	if r == nil {
 		return 0
 	}
+	r = r.shell()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	return r.ForegroundPgid
 }
 
-// Result captures the exit status.
+// childRunner returns a runner with the configuration, variables and
+// descriptors of r that runs until ctx is done. The job table, flags,
+// signal handling and terminal stay with the shell.
+func (r *Runner) childRunner(ctx context.Context) *Runner {
+	return &Runner{
+		Env:         r.Env,
+		Builtins:    r.Builtins,
+		Trace:       r.Trace,
+		TraceWriter: r.TraceWriter,
+		Interactive: r.Interactive,
+		JobControl:  r.JobControl,
+		TTYFD:       r.TTYFD,
+		ShellPgid:   r.ShellPgid,
+		SelfPath:    r.SelfPath,
+		Executor:    r.Executor,
+		KillDelay:   r.KillDelay,
+		FS:          r.FileSystem(),
+		TraceJSON:   r.TraceJSON,
+		fds:         r.fds,
+		nested:      r.nested,
+		ctx:         ctx,
+		tracer:      r.tracer,
+		traceDepth:  r.traceDepth,
+		parent:      r.shell(),
+	}
+}
+
+// stageRunner returns a runner for work that runs alongside the shell,
+// such as a pipeline stage inside the shell or a function called in the
+// background. Like a forked rc it has its own variables, descriptors,
+// status and working directory, so the stage cannot disturb those of the
+// shell.
+func (r *Runner) stageRunner(fds fdTable) *Runner {
+	stage := r.childRunner(r.ctx)
+	stage.FS = forkFS(stage.FS)
+	stage.Env = NewChild(r.Env)
+	stage.Env.runner = stage
+	stage.fds = fds
+	stage.nested = true
+	return stage
+}
+
+// shell returns the runner of the shell itself: r, or the runner a child
+// runner was made for.
+func (r *Runner) shell() *Runner {
+	if r != nil && r.parent != nil {
+		return r.parent
+	}
+	return r
+}
+
+// Result captures the exit status. List holds the rc status, one entry
+// per pipeline stage; Status is the same status as a process exit code.
 type Result struct {
 	Status int
+	List   Status
 }
 
 // RunPlan executes a plan tree and returns the final status.
 func (r *Runner) RunPlan(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Result {
+	r.setup()
+	return r.runPlan(p, stdin, stdout, stderr)
+}
+
+// setup fills in the defaults of the fields a run needs.
+func (r *Runner) setup() {
 	if r.Env == nil {
 		r.Env = NewEnv(nil)
 	}
@@ -79,14 +171,27 @@ func (r *Runner) RunPlan(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer)
 	if r.Jobs == nil {
 		r.Jobs = make(map[int]*Job)
 	}
+	if r.FS == nil {
+		r.FS = NewOSFS("")
+	}
+	if r.tracer == nil {
+		r.tracer = &tracer{}
+	}
 	if r.Trace && r.TraceWriter == nil {
 		r.TraceWriter = io.Discard
 	}
 	if r.Interactive && r.ShellPgid == 0 {
 		r.ShellPgid = unix.Getpgrp()
 	}
+}
+
+func (r *Runner) runPlan(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Result {
+	if env := r.Env; env.runner == nil {
+		env.runner = r
+		defer func() { env.runner = nil }()
+	}
 	status := r.runChain(p, stdin, stdout, stderr)
-	return Result{Status: status}
+	return Result{Status: status.Code(), List: status}
 }
 
 // CallFunc invokes a defined rc function by name.
@@ -99,82 +204,71 @@ func (r *Runner) CallFunc(name string, args []string, stdin io.Reader, stdout, s
 		return 1
 	}
 	argv := append([]string{name}, args...)
-	return r.runFuncCall(def, argv, &ExecPlan{}, r.Env, stdin, stdout, stderr, false)
+	return r.runFuncCall(def, argv, &ExecPlan{}, r.Env, stdin, stdout, stderr, r.fds, false).Code()
 }
 
-func (r *Runner) runChain(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) int {
-	status := 0
+func (r *Runner) runChain(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Status {
+	status := statusTrue
 	for cur := p; cur != nil; cur = cur.Next {
+		r.HandleSignals(stdin, stdout, stderr)
+		r.pruneAPID()
 		if r.exitRequested {
-			return r.exitCode
+			return r.exitStatus
+		}
+		if r.interrupted() {
+			return statusInterrupted
 		}
 		if r.returnRequested && r.returnDepth > 0 {
-			return r.returnCode
+			return r.returnStatus
 		}
 		if cur.Background {
 			status = r.startBackground(cur, stdin, stdout, stderr)
-			r.Env.SetStatus(status)
+			r.Env.SetStatusList(status)
 			continue
 		}
 		status = r.runSingle(cur, stdin, stdout, stderr)
-		r.Env.SetStatus(status)
+		if r.interrupted() {
+			status = statusInterrupted
+		}
+		r.Env.SetStatusList(status)
+		r.checkErrExit(cur, status)
 		if r.exitRequested {
-			return r.exitCode
+			return r.exitStatus
 		}
 		if r.returnRequested && r.returnDepth > 0 {
-			return r.returnCode
+			return r.returnStatus
 		}
-		if status == 0 && cur.IfOK != nil {
+		if status.OK() && cur.IfOK != nil {
 			status = r.runChain(cur.IfOK, stdin, stdout, stderr)
-			r.Env.SetStatus(status)
+			r.Env.SetStatusList(status)
 		}
-		if status != 0 && cur.IfFail != nil {
+		if !status.OK() && cur.IfFail != nil {
 			status = r.runChain(cur.IfFail, stdin, stdout, stderr)
-			r.Env.SetStatus(status)
+			r.Env.SetStatusList(status)
 		}
 	}
 	return status
 }
 
-func (r *Runner) runSingle(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) int {
+func (r *Runner) runSingle(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Status {
 	if p == nil {
-		return 0
+		return statusTrue
 	}
-	if p.PipeTo != nil {
-		return r.runPipe(p, p.PipeTo, stdin, stdout, stderr, false)
-	}
-	return r.runStage(p, stdin, stdout, stderr, false)
+	return r.runNode(p, stdin, stdout, stderr, false)
 }
 
-func (r *Runner) runPipe(left, right *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, background bool) int {
-	if background {
-		if status, ok := r.runPipeExternal(left, right, stdin, stdout, stderr, true); ok {
-			return status
-		}
-		return r.runPipeFallback(left, right, stdin, stdout, stderr, true)
-	}
-	if status, ok := r.runPipeExternal(left, right, stdin, stdout, stderr, false); ok {
+// runNode runs p, a pipeline or a single stage, as one trace event.
+func (r *Runner) runNode(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, background bool) Status {
+	if p.PipeTo != nil {
+		span := r.traceBegin(p, "pipe", stderr)
+		span.nest()
+		status := r.runPipeline(p, stdin, stdout, stderr, r.fds, background)
+		span.end(status)
 		return status
 	}
-	return r.runPipeFallback(left, right, stdin, stdout, stderr, false)
-}
-
-func (r *Runner) runPipeFallback(left, right *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, background bool) int {
-	pr, pw := io.Pipe()
-	leftDone := make(chan int, 1)
-	rightDone := make(chan int, 1)
-
-	go func() {
-		leftDone <- r.runStage(left, stdin, pw, stderr, background)
-		_ = pw.Close()
-	}()
-	go func() {
-		rightDone <- r.runStage(right, pr, stdout, stderr, background)
-		_ = pr.Close()
-	}()
-
-	_ = <-leftDone
-	status := <-rightDone
+	span := r.traceBegin(p, traceKind(p), stderr)
+	status := r.runStage(p, stdin, stdout, stderr, r.fds, background, span)
+	span.end(status)
 	return status
 }
 
@@ -183,17 +277,16 @@ type stagePrep struct {
 	env  *Env
 }
 
-func (r *Runner) prepareExternal(p *ExecPlan) (stagePrep, bool, error) {
-	if p == nil || p.Kind == PlanFnDef || p.Kind == PlanAssign {
-		return stagePrep{}, false, nil
-	}
+// prepareCommand expands the prefix assignments and words of a simple
+// command.
+func (r *Runner) prepareCommand(p *ExecPlan) (stagePrep, error) {
 	execEnv := r.Env
 	if len(p.Prefix) > 0 {
 		child := NewChild(r.Env)
 		for _, pref := range p.Prefix {
 			vals, err := ExpandValue(pref.Val, child)
 			if err != nil {
-				return stagePrep{}, false, err
+				return stagePrep{}, err
 			}
 			child.Set(pref.Name, vals)
 		}
@@ -201,128 +294,40 @@ func (r *Runner) prepareExternal(p *ExecPlan) (stagePrep, bool, error) {
 	}
 	argv, err := r.expandArgv(p, execEnv)
 	if err != nil {
-		return stagePrep{}, false, err
+		return stagePrep{}, err
 	}
-	if len(argv) == 0 {
-		return stagePrep{}, false, nil
-	}
-	if _, ok := execEnv.GetFunc(argv[0]); ok {
-		return stagePrep{}, false, nil
-	}
-	if _, ok := r.Builtins[argv[0]]; ok {
-		return stagePrep{}, false, nil
-	}
-	return stagePrep{argv: argv, env: execEnv}, true, nil
+	return stagePrep{argv: argv, env: execEnv}, nil
 }
 
-func (r *Runner) runPipeExternal(left, right *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, background bool) (int, bool) {
-	leftPrep, ok, err := r.prepareExternal(left)
-	if err != nil {
-		return 1, true
-	}
-	if !ok {
-		return 0, false
-	}
-	rightPrep, ok, err := r.prepareExternal(right)
-	if err != nil {
-		return 1, true
-	}
-	if !ok {
-		return 0, false
-	}
-	r.tracef("+ %s\n", strings.Join(leftPrep.argv, " "))
-	r.tracef("+ %s\n", strings.Join(rightPrep.argv, " "))
-
-	pr, pw, err := os.Pipe()
-	if err != nil {
-		return 1, true
-	}
-	leftPath, ok := resolvePath(leftPrep.argv[0], leftPrep.env, true, stderr)
-	if !ok {
-		_ = pw.Close()
-		_ = pr.Close()
-		return 127, true
-	}
-	leftCmd, leftCleanup, err := buildCmd(leftPath, leftPrep.argv, left, r, stdin, pw, stderr)
-	if err != nil {
-		_ = pw.Close()
-		_ = pr.Close()
-		return 1, true
-	}
-	rightPath, ok := resolvePath(rightPrep.argv[0], rightPrep.env, true, stderr)
-	if !ok {
-		leftCleanup()
-		_ = pw.Close()
-		_ = pr.Close()
-		return 127, true
-	}
-	rightCmd, rightCleanup, err := buildCmd(rightPath, rightPrep.argv, right, r, pr, stdout, stderr)
-	if err != nil {
-		leftCleanup()
-		_ = pw.Close()
-		_ = pr.Close()
-		return 1, true
-	}
-	defer leftCleanup()
-	defer rightCleanup()
-
-	if background || r.JobControl {
-		leftCmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
-	}
-	if err := leftCmd.Start(); err != nil {
-		_ = pw.Close()
-		_ = pr.Close()
-		return exitStatus(err), true
-	}
-	leader := leftCmd.Process.Pid
-
-	if background || r.JobControl {
-		rightCmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true, Pgid: leader}
-	}
-	if err := rightCmd.Start(); err != nil {
-		_ = leftCmd.Process.Kill()
-		_ = leftCmd.Wait()
-		_ = pw.Close()
-		_ = pr.Close()
-		return exitStatus(err), true
-	}
-	_ = pw.Close()
-	_ = pr.Close()
-
-	if background {
-		job := r.onBackgroundStart(leader, []int{leftCmd.Process.Pid, rightCmd.Process.Pid}, strings.Join(leftPrep.argv, " ")+" | "+strings.Join(rightPrep.argv, " "))
-		go r.waitJobPids(job, []int{leftCmd.Process.Pid, rightCmd.Process.Pid})
-		return 0, true
+// isExternal reports whether a prepared command names a program rather
+// than a function or builtin.
+func (r *Runner) isExternal(prep stagePrep) bool {
+	if len(prep.argv) == 0 {
+		return false
 	}
-	if r.JobControl {
-		r.attachForeground(leader)
-	}
-	leftDone := make(chan int, 1)
-	rightDone := make(chan int, 1)
-	go func() {
-		err := leftCmd.Wait()
-		leftDone <- exitStatus(err)
-	}()
-	go func() {
-		err := rightCmd.Wait()
-		rightDone <- exitStatus(err)
-	}()
-	_ = <-leftDone
-	status := <-rightDone
-	if r.JobControl {
-		r.restoreForeground()
+	if _, ok := prep.env.GetFunc(prep.argv[0]); ok {
+		return false
 	}
-	return status, true
+	_, ok := r.Builtins[prep.argv[0]]
+	return !ok
 }
 
-func (r *Runner) runStage(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, background bool) int {
+// runStage runs p on its own. Commands and assignments fill in the
+// expanded words of span.
+func (r *Runner) runStage(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, fds fdTable, background bool, span *traceSpan) Status {
 	if p == nil {
-		return 0
+		return statusTrue
+	}
+	switch p.Kind {
+	case PlanIf, PlanFor, PlanWhile, PlanSwitch, PlanNot, PlanSubshell:
+		orig := r.fds
+		r.fds = fds
+		defer func() { r.fds = orig }()
 	}
 	switch p.Kind {
 	case PlanIf:
-		condStatus := r.runAST(p.IfCond, stdin, stdout, stderr)
-		if condStatus == 0 {
+		condStatus := r.runCond(p.IfCond, stdin, stdout, stderr)
+		if condStatus.OK() {
 			return r.runAST(p.IfBody, stdin, stdout, stderr)
 		}
 		if p.IfElse != nil {
@@ -336,131 +341,198 @@ func (r *Runner) runStage(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer
 	case PlanSwitch:
 		return r.runSwitch(p, stdin, stdout, stderr)
 	case PlanNot:
-		status := r.runAST(p.NotBody, stdin, stdout, stderr)
-		if status == 0 {
-			return 1
-		}
-		return 0
+		status := r.runCond(p.NotBody, stdin, stdout, stderr)
+		return boolStatus(!status.OK())
 	case PlanSubshell:
 		return r.runSubshell(p.SubBody, stdin, stdout, stderr)
+	case PlanBlock:
+		return r.runBlock(p, stdin, stdout, stderr, fds)
 	case PlanTwiddle:
 		return r.runMatch(p)
 	case PlanFnRm:
 		if p.Func != nil {
 			r.Env.UnsetFunc(p.Func.Name)
+			r.watchSignals()
 		}
-		return 0
+		return statusTrue
 	}
 	if p.Kind == PlanFnDef {
 		if p.Func != nil && p.Func.Name != "" {
 			r.Env.SetFunc(p.Func.Name, p.Func.Body)
+			r.watchSignals()
 		}
-		return 0
+		return statusTrue
 	}
 	if p.Kind == PlanAssign {
 		vals, err := ExpandValue(p.AssignVal, r.Env)
 		if err != nil {
-			return 1
+			return statusFalse
 		}
 		r.Env.Set(p.AssignName, vals)
-		return 0
+		span.setArgv(append([]string{p.AssignName}, vals...))
+		return statusTrue
 	}
-	execEnv := r.Env
-	if len(p.Prefix) > 0 {
-		child := NewChild(r.Env)
-		for _, pref := range p.Prefix {
-			vals, err := ExpandValue(pref.Val, child)
-			if err != nil {
-				return 1
-			}
-			child.Set(pref.Name, vals)
-		}
-		execEnv = child
+	prep, err := r.prepareCommand(p)
+	if err != nil {
+		return statusFalse
+	}
+	return r.runCommand(p, prep, stdin, stdout, stderr, fds, background, span)
+}
+
+// runBlock opens the redirections of a brace block once, so that every
+// command in it writes to the same files and sees the same descriptors,
+// and restores the descriptor table when the block ends.
+func (r *Runner) runBlock(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, base fdTable) Status {
+	in, out, errOut := stdin, stdout, stderr
+	fds := base.clone()
+	files, err := applyRedirs(p, r, &in, &out, &errOut, fds)
+	for _, f := range files {
+		defer f.Close()
 	}
-	argv, err := r.expandArgv(p, execEnv)
 	if err != nil {
-		return 1
+		fmt.Fprintf(stderr, "rc: %v\n", err)
+		return statusFalse
 	}
+	orig := r.fds
+	r.fds = fds
+	defer func() { r.fds = orig }()
+	return r.runChain(p.Block, in, out, errOut)
+}
+
+func (r *Runner) runCommand(p *ExecPlan, prep stagePrep, stdin io.Reader, stdout, stderr io.Writer, fds fdTable, background bool, span *traceSpan) Status {
+	argv, execEnv := prep.argv, prep.env
 	if len(argv) == 0 {
-		return 0
+		return statusTrue
 	}
 	r.tracef("+ %s\n", strings.Join(argv, " "))
+	span.setArgv(argv)
 	if def, ok := execEnv.GetFunc(argv[0]); ok {
-		return r.runFuncCall(def, argv, p, execEnv, stdin, stdout, stderr, background)
+		span.setKind("function")
+		return r.runFuncCall(def, argv, p, execEnv, stdin, stdout, stderr, fds, background)
 	}
 	if builtin, ok := r.Builtins[argv[0]]; ok {
-		return r.runBuiltin(builtin, argv, p, execEnv, stdin, stdout, stderr)
+		span.setKind("builtin")
+		return r.runBuiltin(builtin, argv, p, execEnv, stdin, stdout, stderr, fds)
 	}
-	return r.runExternal(argv, p, stdin, stdout, stderr, background, 0)
+	return r.runExternal(argv, p, execEnv, stdin, stdout, stderr, fds, background, 0, span)
 }
 
-func (r *Runner) runBuiltin(builtin Builtin, argv []string, p *ExecPlan, env *Env, stdin io.Reader, stdout, stderr io.Writer) int {
+func (r *Runner) runBuiltin(builtin Builtin, argv []string, p *ExecPlan, env *Env, stdin io.Reader, stdout, stderr io.Writer, base fdTable) Status {
 	in := stdin
 	out := stdout
 	errOut := stderr
-	files, err := applyRedirs(p, r, &in, &out, &errOut)
+	fds := base.clone()
+	files, err := applyRedirs(p, r, &in, &out, &errOut, fds)
 	if err != nil {
-		return 1
+		fmt.Fprintf(stderr, "rc: %v\n", err)
+		return statusFalse
 	}
 	for _, f := range files {
 		defer f.Close()
 	}
 	orig := r.Env
+	origFDs := r.fds
 	r.Env = env
-	status := builtin(in, out, errOut, argv, r)
+	r.fds = fds
+	r.builtinStatus = nil
+	status := StatusCode(builtin(in, out, errOut, argv, r))
+	if r.builtinStatus != nil {
+		status = r.builtinStatus
+		r.builtinStatus = nil
+	}
 	r.Env = orig
+	r.fds = origFDs
 	return status
 }
 
-func (r *Runner) runExternal(argv []string, p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer, background bool, wantPgid int) int {
-	execPath, ok := resolvePath(argv[0], r.Env, true, stderr)
-	if !ok {
-		return 127
+// builtinResult records s as the full status of the running builtin, for
+// builtins whose status is not a plain number, and returns its exit code.
+func (r *Runner) builtinResult(s Status) int {
+	r.builtinStatus = s
+	return s.Code()
+}
+
+func (r *Runner) runExternal(argv []string, p *ExecPlan, env *Env, stdin io.Reader, stdout, stderr io.Writer, fds fdTable, background bool, wantPgid int, span *traceSpan) Status {
+	if r.JobControl {
+		// a stopped command keeps its output copiers while the shell
+		// reports the stop on the same writers
+		stdout, stderr = shareWriters(stdout, stderr)
 	}
-	cmd, cleanup, err := buildCmd(execPath, argv, p, r, stdin, stdout, stderr)
+	c, cleanup, err := buildCommand(argv, p, r, env, stdin, stdout, stderr, fds)
 	if err != nil {
-		return 127
-	}
-	if cmd == nil {
-		return 0
-	}
-	defer cleanup()
-	if wantPgid != 0 {
-		cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true, Pgid: wantPgid}
-	} else if background || r.JobControl {
-		cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+		fmt.Fprintf(stderr, "rc: %v\n", err)
+		return StatusCode(127)
 	}
-	if err := cmd.Start(); err != nil {
-		return exitStatus(err)
+	c.Setpgid = wantPgid != 0 || background || r.JobControl || r.cancellable()
+	c.Pgid = wantPgid
+	proc, status := r.startCommand(c, stderr)
+	if proc == nil {
+		cleanup()
+		return status
 	}
+	pid := proc.Pid()
+	span.setProcess(pid, commandGroup(c, pid))
 	if background {
-		pgid, err := unix.Getpgid(cmd.Process.Pid)
-		if err != nil {
-			pgid = cmd.Process.Pid
+		pgid := pid
+		if pid > 0 {
+			if g, err := unix.Getpgid(pid); err == nil {
+				pgid = g
+			}
 		}
-		job := r.onBackgroundStart(pgid, []int{cmd.Process.Pid}, strings.Join(argv, " "))
-		go r.waitJobPids(job, []int{cmd.Process.Pid})
-		return 0
+		job := r.onBackgroundStart(pgid, []int{pid}, strings.Join(argv, " "))
+		job.procs = []Process{proc}
+		go r.waitJobPids(job, []int{pid})
+		go cleanup()
+		return statusTrue
+	}
+	pgid := pid
+	if wantPgid != 0 {
+		pgid = wantPgid
 	}
 	if r.JobControl {
-		r.attachForeground(cmd.Process.Pid)
-		err = cmd.Wait()
-		r.restoreForeground()
-	} else {
-		signal.Ignore(syscall.SIGINT)
-		err = cmd.Wait()
-		signal.Reset(syscall.SIGINT)
+		job := newJob(pgid, []int{pid}, strings.Join(argv, " "))
+		job.procs = []Process{proc}
+		job.cleanup = append(job.cleanup, cleanup)
+		status, _ := r.runForegroundJob(job, stderr)
+		return status
 	}
-	return exitStatus(err)
+	defer cleanup()
+	stop := r.killOnCancel(pgid, []Process{proc})
+	r.waitForeground(0, func() {
+		status = proc.Wait()
+	})
+	stop()
+	return status
 }
 
-func (r *Runner) runFuncCall(def FuncDef, argv []string, p *ExecPlan, env *Env, stdin io.Reader, stdout, stderr io.Writer, background bool) int {
+// waitForeground runs wait while the foreground work it waits for owns the
+// terminal. Without job control the children share the shell's process
+// group, so the shell catches SIGINT until they are done rather than
+// dying with them; a sigint handler still sees the signal.
+func (r *Runner) waitForeground(pgid int, wait func()) {
+	if !r.JobControl {
+		hold := make(chan os.Signal, 1)
+		signal.Notify(hold, syscall.SIGINT)
+		wait()
+		signal.Stop(hold)
+		return
+	}
+	if pgid != 0 {
+		r.attachForegroundPgid(pgid)
+	}
+	wait()
+	r.restoreForeground()
+}
+
+func (r *Runner) runFuncCall(def FuncDef, argv []string, p *ExecPlan, env *Env, stdin io.Reader, stdout, stderr io.Writer, base fdTable, background bool) Status {
 	in := stdin
 	out := stdout
 	errOut := stderr
-	files, err := applyRedirs(p, r, &in, &out, &errOut)
+	fds := base.clone()
+	files, err := applyRedirs(p, r, &in, &out, &errOut, fds)
 	if err != nil {
-		return 1
+		fmt.Fprintf(stderr, "rc: %v\n", err)
+		return statusFalse
 	}
 	for _, f := range files {
 		defer f.Close()
@@ -474,107 +546,104 @@ func (r *Runner) runFuncCall(def FuncDef, argv []string, p *ExecPlan, env *Env,
 	child.Set("0", []string{argv[0]})
 	bodyPlan, err := BuildPlan(def.Body, child)
 	if err != nil {
-		return 1
+		return statusFalse
 	}
-	origEnv := r.Env
-	r.Env = child
 	if background {
-		go r.runChain(bodyPlan, in, out, stderr)
-		r.Env = origEnv
-		return 0
+		stage := r.stageRunner(fds)
+		stage.Env = child
+		child.runner = stage
+		go stage.runChain(bodyPlan, in, out, stderr)
+		return statusTrue
 	}
+	origEnv := r.Env
+	origFDs := r.fds
+	r.Env = child
+	r.fds = fds
 	r.returnDepth++
 	status := r.runChain(bodyPlan, in, out, errOut)
 	if r.returnRequested {
-		status = r.returnCode
+		status = r.returnStatus
 		r.returnRequested = false
-		r.returnCode = 0
+		r.returnStatus = nil
 	}
 	r.returnDepth--
 	r.Env = origEnv
+	r.fds = origFDs
 	return status
 }
 
-func (r *Runner) runSubshell(n *parse.Node, stdin io.Reader, stdout, stderr io.Writer) int {
+func (r *Runner) runSubshell(n *parse.Node, stdin io.Reader, stdout, stderr io.Writer) Status {
 	if r == nil {
-		return 1
+		return statusFalse
 	}
 	childEnv := NewChild(r.Env)
 	if r.SelfPath == "" {
-		return r.runASTWithEnv(childEnv, n, stdin, stdout, stderr)
+		return r.stageRunner(r.fds).runAST(n, stdin, stdout, stderr)
 	}
 	src, err := parse.Format(n)
 	if err != nil {
-		return r.runASTWithEnv(childEnv, n, stdin, stdout, stderr)
+		return r.stageRunner(r.fds).runAST(n, stdin, stdout, stderr)
 	}
-	cmd := exec.Command(r.SelfPath, "-c", src)
+	args := append(r.subshellFlags(), "-c", src)
+	cmd := exec.Command(r.SelfPath, args...)
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	cmd.Env = buildExecEnv(childEnv)
-	if r.JobControl {
+	cmd.Env = withoutExitHandler(buildExecEnv(childEnv))
+	cmd.Dir, _ = r.FileSystem().Getwd()
+	if r.JobControl || r.cancellable() {
 		cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
 	}
 	if err := cmd.Start(); err != nil {
-		return exitStatus(err)
+		return errStatus(err)
 	}
 	if r.JobControl {
-		r.attachForeground(cmd.Process.Pid)
-		err = cmd.Wait()
-		r.restoreForeground()
-		return exitStatus(err)
+		job := newJob(cmd.Process.Pid, []int{cmd.Process.Pid}, src)
+		job.cleanup = append(job.cleanup, func() { _ = cmd.Wait() })
+		status, _ := r.runForegroundJob(job, stderr)
+		return status
 	}
-	return exitStatus(cmd.Wait())
+	stop := r.killOnCancel(cmd.Process.Pid, nil)
+	defer stop()
+	return errStatus(cmd.Wait())
 }
 
-func (r *Runner) runAST(n *parse.Node, stdin io.Reader, stdout, stderr io.Writer) int {
+func (r *Runner) runAST(n *parse.Node, stdin io.Reader, stdout, stderr io.Writer) Status {
 	if n == nil {
-		return 0
+		return statusTrue
 	}
 	plan, err := BuildPlan(n, r.Env)
 	if err != nil {
-		return 1
+		return statusFalse
 	}
 	return r.runChain(plan, stdin, stdout, stderr)
 }
 
-func (r *Runner) runASTWithEnv(env *Env, n *parse.Node, stdin io.Reader, stdout, stderr io.Writer) int {
-	if n == nil {
-		return 0
-	}
-	plan, err := BuildPlan(n, env)
-	if err != nil {
-		return 1
-	}
-	orig := r.Env
-	r.Env = env
-	status := r.runChain(plan, stdin, stdout, stderr)
-	r.Env = orig
-	return status
-}
-
-func (r *Runner) runFor(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) int {
+func (r *Runner) runFor(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Status {
 	if p.ForName == "" {
-		return 1
+		return statusFalse
 	}
 	var list []string
 	if p.ForList != nil {
 		vals, err := ExpandValue(p.ForList, r.Env)
 		if err != nil {
-			return 1
+			return statusFalse
 		}
 		list = vals
 	} else {
 		list = r.Env.Get("*")
 	}
 	if len(list) == 0 {
-		return 0
+		return statusTrue
 	}
-	status := 0
+	status := statusTrue
 	for _, val := range list {
+		if r.interrupted() {
+			return statusInterrupted
+		}
 		r.Env.Set(p.ForName, []string{val})
 		status = r.runAST(p.ForBody, stdin, stdout, stderr)
-		r.Env.SetStatus(status)
+		r.Env.SetStatusList(status)
 		if r.exitRequested {
 			break
 		}
@@ -582,27 +651,30 @@ func (r *Runner) runFor(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer)
 	return status
 }
 
-func (r *Runner) runWhile(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) int {
-	status := 0
+func (r *Runner) runWhile(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Status {
+	status := statusTrue
 	for {
-		cond := r.runAST(p.WhileCond, stdin, stdout, stderr)
-		if cond != 0 {
+		if r.interrupted() {
+			return statusInterrupted
+		}
+		cond := r.runCond(p.WhileCond, stdin, stdout, stderr)
+		if !cond.OK() {
 			return status
 		}
 		status = r.runAST(p.WhileBody, stdin, stdout, stderr)
-		r.Env.SetStatus(status)
+		r.Env.SetStatusList(status)
 		if r.exitRequested {
 			return status
 		}
 	}
 }
 
-func (r *Runner) runSwitch(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) int {
+func (r *Runner) runSwitch(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Status {
 	arg := ""
 	if p.SwitchArg != nil {
 		vals, err := ExpandWordNoGlob(p.SwitchArg, r.Env)
 		if err != nil {
-			return 1
+			return statusFalse
 		}
 		if len(vals) > 0 {
 			arg = vals[0]
@@ -610,12 +682,12 @@ func (r *Runner) runSwitch(p *ExecPlan, stdin io.Reader, stdout, stderr io.Write
 	}
 	cases, err := switchCases(p.SwitchBody, r.Env)
 	if err != nil {
-		return 1
+		return statusFalse
 	}
 	if len(cases) == 0 {
-		return 0
+		return statusTrue
 	}
-	status := 0
+	status := statusTrue
 	matched := false
 	for _, c := range cases {
 		if !matched && matchAnyPattern(arg, c.Patterns) {
@@ -623,58 +695,35 @@ func (r *Runner) runSwitch(p *ExecPlan, stdin io.Reader, stdout, stderr io.Write
 		}
 		if matched {
 			status = r.runAST(c.Body, stdin, stdout, stderr)
-			r.Env.SetStatus(status)
+			r.Env.SetStatusList(status)
 		}
 	}
 	return status
 }
 
-func (r *Runner) runMatch(p *ExecPlan) int {
+func (r *Runner) runMatch(p *ExecPlan) Status {
 	subjects, err := ExpandWordNoGlob(p.MatchSubj, r.Env)
 	if err != nil {
-		return 1
+		return statusFalse
 	}
 	if len(subjects) == 0 {
-		return 1
+		return statusFalse
 	}
 	patterns, err := ExpandWordsNoGlob(p.MatchPats, r.Env)
 	if err != nil {
-		return 1
+		return statusFalse
 	}
 	if len(patterns) == 0 {
-		return 1
+		return statusFalse
 	}
 	for _, subj := range subjects {
 		for _, pat := range patterns {
 			if rcMatch(pat, subj) {
-				return 0
+				return statusTrue
 			}
 		}
 	}
-	return 1
-}
-
-func buildCmd(path string, argv []string, p *ExecPlan, r *Runner, stdin io.Reader, stdout, stderr io.Writer) (*exec.Cmd, func(), error) {
-	if p == nil || len(argv) == 0 {
-		return nil, func() {}, nil
-	}
-	cmd := exec.Command(path, argv[1:]...)
-	cmd.Args = argv
-	cmd.Stdin = stdin
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-
-	files, err := applyRedirs(p, r, &cmd.Stdin, &cmd.Stdout, &cmd.Stderr)
-	cleanup := func() {
-		for _, f := range files {
-			_ = f.Close()
-		}
-	}
-	if err != nil {
-		cleanup()
-		return nil, func() {}, err
-	}
-	return cmd, cleanup, nil
+	return statusFalse
 }
 
 func (r *Runner) expandArgv(p *ExecPlan, env *Env) ([]string, error) {
@@ -687,53 +736,33 @@ func (r *Runner) expandArgv(p *ExecPlan, env *Env) ([]string, error) {
 	return ExpandCall(p.Call, env)
 }
 
-func (r *Runner) startBackground(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) int {
+// startBackground starts p without waiting for it. A stdin that is not a
+// file is copied into each command by a goroutine of its own, which would
+// race the shell's later commands for it, so a background command reads
+// nothing instead.
+func (r *Runner) startBackground(p *ExecPlan, stdin io.Reader, stdout, stderr io.Writer) Status {
 	if p == nil {
-		return 0
+		return statusTrue
 	}
-	if p.PipeTo != nil {
-		go r.runPipe(p, p.PipeTo, stdin, stdout, stderr, true)
-		return 0
+	if _, ok := stdin.(*os.File); !ok {
+		stdin = strings.NewReader("")
 	}
-	return r.runStage(p, stdin, stdout, stderr, true)
+	return r.runNode(p, stdin, stdout, stderr, true)
 }
 
 func (r *Runner) onBackgroundStart(pgid int, pids []int, cmd string) *Job {
 	for _, pid := range pids {
-		r.addAPID(pid)
-	}
-	return r.addJob(pgid, pids, cmd)
-}
-
-func (r *Runner) waitJobPids(job *Job, pids []int) {
-	if job == nil {
-		return
-	}
-	exit := 0
-	for _, pid := range pids {
-		for {
-			var ws unix.WaitStatus
-			_, err := unix.Wait4(pid, &ws, 0, nil)
-			if err == unix.EINTR {
-				continue
-			}
-			if err != nil {
-				break
-			}
-			exit = ws.ExitStatus()
-			break
+		if pid > 0 {
+			r.addAPID(pid)
 		}
-		r.removeAPID(pid)
-	}
-	r.markJobDone(job.Pgid, exit)
-	select {
-	case job.Done <- exit:
-	default:
 	}
+	job := r.addJob(pgid, pids, cmd)
+	job.env = r.Env
+	return job
 }
 
 func (r *Runner) attachForeground(pid int) {
-	if !r.Interactive || r.TTYFD <= 0 {
+	if pid <= 0 || !r.Interactive || r.TTYFD <= 0 {
 		return
 	}
 	pgid, err := unix.Getpgid(pid)
@@ -744,6 +773,7 @@ func (r *Runner) attachForeground(pid int) {
 }
 
 func (r *Runner) restoreForeground() {
+	r = r.shell()
 	if !r.Interactive || r.TTYFD <= 0 {
 		r.mu.Lock()
 		r.ForegroundPgid = 0
@@ -751,24 +781,22 @@ func (r *Runner) restoreForeground() {
 		return
 	}
 	if r.ShellPgid != 0 {
-		signal.Ignore(syscall.SIGTTOU)
-		defer signal.Reset(syscall.SIGTTOU)
 		err := r.setForegroundPgrp(r.ShellPgid)
 		if err != nil {
 			r.tracef("tcsetpgrp restore failed: %v\n", err)
 		}
 	}
+	r.setTerminalModes(r.shellModes)
 	r.mu.Lock()
 	r.ForegroundPgid = 0
 	r.mu.Unlock()
 }
 
 func (r *Runner) attachForegroundPgid(pgid int) {
-	if !r.Interactive || r.TTYFD <= 0 {
+	r = r.shell()
+	if pgid <= 0 || !r.Interactive || r.TTYFD <= 0 {
 		return
 	}
-	signal.Ignore(syscall.SIGTTOU)
-	defer signal.Reset(syscall.SIGTTOU)
 	err := r.setForegroundPgrp(pgid)
 	if err != nil {
 		r.tracef("tcsetpgrp failed: %v\n", err)
@@ -779,6 +807,9 @@ func (r *Runner) attachForegroundPgid(pgid int) {
 	r.mu.Unlock()
 }
 
+// setForegroundPgrp hands the terminal to pgid. The shell ignores SIGTTOU
+// for its whole life, so the call succeeds while the shell itself is in
+// the background.
 func (r *Runner) setForegroundPgrp(pgid int) error {
 	if r.TTYFD <= 0 {
 		return fmt.Errorf("invalid tty fd")
@@ -793,27 +824,29 @@ func (r *Runner) tracef(format string, args ...any) {
 	fmt.Fprintf(r.TraceWriter, format, args...)
 }
 
-func (r *Runner) requestReturn(code int) {
+func (r *Runner) requestReturn(status Status) {
 	r.returnRequested = true
-	r.returnCode = code
+	r.returnStatus = status
 }
 
-func applyRedirs(p *ExecPlan, runner *Runner, stdin *io.Reader, stdout, stderr *io.Writer) ([]*os.File, error) {
+func applyRedirs(p *ExecPlan, runner *Runner, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable) ([]io.Closer, error) {
 	if p == nil {
 		return nil, nil
 	}
-	var files []*os.File
+	var files []io.Closer
 	for _, redir := range p.Redirs {
 		if redir.Nmpipe != nil {
-			nf, err := applyNmpipe(redir, runner, stdin, stdout, stderr)
+			nf, err := applyNmpipe(redir, runner, stdin, stdout, stderr, fds)
 			if err != nil {
 				return files, err
 			}
-			files = append(files, nf...)
+			for _, f := range nf {
+				files = append(files, f)
+			}
 			continue
 		}
 		if redir.Op == "dup" {
-			if err := applyDup(redir, stdin, stdout, stderr, &files); err != nil {
+			if err := applyDup(redir, stdin, stdout, stderr, fds, &files); err != nil {
 				return files, err
 			}
 			continue
@@ -822,47 +855,48 @@ func applyRedirs(p *ExecPlan, runner *Runner, stdin *io.Reader, stdout, stderr *
 			continue
 		}
 		path := redir.Target[0]
+		fsys := runner.FileSystem()
 		fd := redir.Fd
 		if fd < 0 {
 			fd = defaultRedirFD(redir.Op)
 		}
 		switch redir.Op {
 		case ">":
-			f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o666)
+			f, err := fsys.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o666)
 			if err != nil {
 				return files, err
 			}
-			if err := assignFD(fd, stdin, stdout, stderr, f); err != nil {
+			if err := assignFD(fd, stdin, stdout, stderr, fds, f); err != nil {
 				_ = f.Close()
 				return files, err
 			}
 			files = append(files, f)
 		case ">>":
-			f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o666)
+			f, err := fsys.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o666)
 			if err != nil {
 				return files, err
 			}
-			if err := assignFD(fd, stdin, stdout, stderr, f); err != nil {
+			if err := assignFD(fd, stdin, stdout, stderr, fds, f); err != nil {
 				_ = f.Close()
 				return files, err
 			}
 			files = append(files, f)
 		case "<":
-			f, err := os.Open(path)
+			f, err := fsys.OpenFile(path, os.O_RDONLY, 0)
 			if err != nil {
 				return files, err
 			}
-			if err := assignFD(fd, stdin, stdout, stderr, f); err != nil {
+			if err := assignFD(fd, stdin, stdout, stderr, fds, f); err != nil {
 				_ = f.Close()
 				return files, err
 			}
 			files = append(files, f)
 		case "<>":
-			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o666)
+			f, err := fsys.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o666)
 			if err != nil {
 				return files, err
 			}
-			if err := assignFD(fd, stdin, stdout, stderr, f); err != nil {
+			if err := assignFD(fd, stdin, stdout, stderr, fds, f); err != nil {
 				_ = f.Close()
 				return files, err
 			}
@@ -877,7 +911,7 @@ func applyRedirs(p *ExecPlan, runner *Runner, stdin *io.Reader, stdout, stderr *
 			if err != nil {
 				return files, err
 			}
-			if err := assignFD(fd, stdin, stdout, stderr, pr); err != nil {
+			if err := assignFD(fd, stdin, stdout, stderr, fds, pr); err != nil {
 				_ = pr.Close()
 				_ = pw.Close()
 				return files, err
@@ -896,7 +930,7 @@ func applyRedirs(p *ExecPlan, runner *Runner, stdin *io.Reader, stdout, stderr *
 	return files, nil
 }
 
-func applyNmpipe(redir RedirPlan, runner *Runner, stdin *io.Reader, stdout, stderr *io.Writer) ([]*os.File, error) {
+func applyNmpipe(redir RedirPlan, runner *Runner, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable) ([]*os.File, error) {
 	if runner == nil || runner.Env == nil || redir.Nmpipe == nil {
 		return nil, fmt.Errorf("nmpipe missing runner")
 	}
@@ -908,10 +942,11 @@ func applyNmpipe(redir RedirPlan, runner *Runner, stdin *io.Reader, stdout, stde
 	if err != nil {
 		return nil, err
 	}
+	stage := runner.stageRunner(fds.clone())
 	var files []*os.File
 	switch {
 	case strings.HasPrefix(redir.Op, "<"):
-		if err := assignFD(fd, stdin, stdout, stderr, pr); err != nil {
+		if err := assignFD(fd, stdin, stdout, stderr, fds, pr); err != nil {
 			_ = pr.Close()
 			_ = pw.Close()
 			return nil, err
@@ -920,11 +955,11 @@ func applyNmpipe(redir RedirPlan, runner *Runner, stdin *io.Reader, stdout, stde
 		in := *stdin
 		errOut := *stderr
 		go func(out *os.File) {
-			_ = runner.runAST(redir.Nmpipe, in, out, errOut)
+			_ = stage.runAST(redir.Nmpipe, in, out, errOut)
 			_ = out.Close()
 		}(pw)
 	case strings.HasPrefix(redir.Op, ">"):
-		if err := assignFD(fd, stdin, stdout, stderr, pw); err != nil {
+		if err := assignFD(fd, stdin, stdout, stderr, fds, pw); err != nil {
 			_ = pr.Close()
 			_ = pw.Close()
 			return nil, err
@@ -933,7 +968,7 @@ func applyNmpipe(redir RedirPlan, runner *Runner, stdin *io.Reader, stdout, stde
 		out := *stdout
 		errOut := *stderr
 		go func(in *os.File) {
-			_ = runner.runAST(redir.Nmpipe, in, out, errOut)
+			_ = stage.runAST(redir.Nmpipe, in, out, errOut)
 			_ = in.Close()
 		}(pr)
 	default:
@@ -951,32 +986,46 @@ func defaultRedirFD(op string) int {
 	return 1
 }
 
-func assignFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, f *os.File) error {
+// assignFD makes f, a file or one end of a pipe, descriptor fd.
+func assignFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable, f any) error {
 	switch fd {
 	case 0:
-		*stdin = f
-	case 1:
-		*stdout = f
-	case 2:
-		*stderr = f
+		rd, ok := f.(io.Reader)
+		if !ok {
+			return fmt.Errorf("fd %d is not readable", fd)
+		}
+		*stdin = rd
+	case 1, 2:
+		w, ok := f.(io.Writer)
+		if !ok {
+			return fmt.Errorf("fd %d is not writable", fd)
+		}
+		if fd == 1 {
+			*stdout = w
+		} else {
+			*stderr = w
+		}
 	default:
-		return fmt.Errorf("unsupported fd %d", fd)
+		if fd < 0 || fds == nil {
+			return fmt.Errorf("unsupported fd %d", fd)
+		}
+		fds[fd] = f
 	}
 	return nil
 }
 
-func applyDup(r RedirPlan, stdin *io.Reader, stdout, stderr *io.Writer, files *[]*os.File) error {
+func applyDup(r RedirPlan, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable, files *[]io.Closer) error {
 	if r.Fd < 0 {
 		return fmt.Errorf("dup missing target fd")
 	}
 	if r.Close {
-		return closeFD(r.Fd, stdin, stdout, stderr, files)
+		return closeFD(r.Fd, stdin, stdout, stderr, fds, files)
 	}
 	if r.Fd == r.DupTo {
 		return nil
 	}
-	srcWriter, srcWriterOK := writerForFD(r.DupTo, stdin, stdout, stderr)
-	srcReader, srcReaderOK := readerForFD(r.DupTo, stdin, stdout, stderr)
+	srcWriter, srcWriterOK := writerForFD(r.DupTo, stdin, stdout, stderr, fds)
+	srcReader, srcReaderOK := readerForFD(r.DupTo, stdin, stdout, stderr, fds)
 	switch r.Fd {
 	case 0:
 		if !srcReaderOK {
@@ -994,12 +1043,16 @@ func applyDup(r RedirPlan, stdin *io.Reader, stdout, stderr *io.Writer, files *[
 		}
 		*stderr = srcWriter
 	default:
-		return fmt.Errorf("unsupported fd %d", r.Fd)
+		src, ok := valueForFD(r.DupTo, stdin, stdout, stderr, fds)
+		if !ok || fds == nil {
+			return fmt.Errorf("dup source fd %d is not open", r.DupTo)
+		}
+		fds[r.Fd] = src
 	}
 	return nil
 }
 
-func closeFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, files *[]*os.File) error {
+func closeFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable, files *[]io.Closer) error {
 	var f *os.File
 	var err error
 	switch fd {
@@ -1008,16 +1061,30 @@ func closeFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, files *[]*os.F
 	case 1, 2:
 		f, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0o666)
 	default:
-		return fmt.Errorf("unsupported fd %d", fd)
+		delete(fds, fd)
+		return nil
 	}
 	if err != nil {
 		return err
 	}
 	*files = append(*files, f)
-	return assignFD(fd, stdin, stdout, stderr, f)
+	return assignFD(fd, stdin, stdout, stderr, fds, f)
 }
 
-func writerForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer) (io.Writer, bool) {
+func valueForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable) (any, bool) {
+	switch fd {
+	case 0:
+		return *stdin, *stdin != nil
+	case 1:
+		return *stdout, *stdout != nil
+	case 2:
+		return *stderr, *stderr != nil
+	}
+	v, ok := fds[fd]
+	return v, ok
+}
+
+func writerForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable) (io.Writer, bool) {
 	switch fd {
 	case 1:
 		return *stdout, true
@@ -1027,11 +1094,15 @@ func writerForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer) (io.Writer
 		if w, ok := (*stdin).(io.Writer); ok {
 			return w, true
 		}
+	default:
+		if w, ok := fds[fd].(io.Writer); ok {
+			return w, true
+		}
 	}
 	return nil, false
 }
 
-func readerForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer) (io.Reader, bool) {
+func readerForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer, fds fdTable) (io.Reader, bool) {
 	switch fd {
 	case 0:
 		return *stdin, true
@@ -1043,16 +1114,10 @@ func readerForFD(fd int, stdin *io.Reader, stdout, stderr *io.Writer) (io.Reader
 		if r, ok := (*stderr).(io.Reader); ok {
 			return r, true
 		}
+	default:
+		if r, ok := fds[fd].(io.Reader); ok {
+			return r, true
+		}
 	}
 	return nil, false
 }
-
-func exitStatus(err error) int {
-	if err == nil {
-		return 0
-	}
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		return exitErr.ExitCode()
-	}
-	return 127
-}
@@ -0,0 +1,150 @@
+package eval
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// trappable lists the signals a script can handle by defining a function
+// named after the signal, such as fn sigint.
+var trappable = []syscall.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGALRM,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// exitHandler is the function run when the shell exits.
+const exitHandler = "sigexit"
+
+// watchSignals catches exactly the trappable signals that currently have a
+// handler function; the others keep their default disposition.
+func (r *Runner) watchSignals() {
+	if r.nested {
+		return
+	}
+	var want []os.Signal
+	for _, sig := range trappable {
+		if _, ok := r.Env.GetFunc(signalStatus(sig, false)); ok {
+			want = append(want, sig)
+		}
+	}
+	sh := r.shell()
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sameSignals(want, sh.sigWatched) {
+		return
+	}
+	if sh.sigc == nil {
+		sh.sigc = make(chan os.Signal, len(trappable))
+	}
+	if !subsetSignals(sh.sigWatched, want) {
+		signal.Stop(sh.sigc)
+	}
+	if len(want) > 0 {
+		signal.Notify(sh.sigc, want...)
+	}
+	sh.sigWatched = want
+}
+
+// subsetSignals reports whether every signal in a is also in b.
+func subsetSignals(a, b []os.Signal) bool {
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSignals(a, b []os.Signal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleSignals runs the handler of every signal caught since the last
+// safe point. The runner calls it between commands; an interactive shell
+// also calls it before each prompt.
+func (r *Runner) HandleSignals(stdin io.Reader, stdout, stderr io.Writer) {
+	if r == nil || r.Env == nil || r.nested {
+		return
+	}
+	r.watchSignals()
+	sh := r.shell()
+	if sh.sigc == nil || sh.inTrap {
+		return
+	}
+	for {
+		select {
+		case sig := <-sh.sigc:
+			if s, ok := sig.(syscall.Signal); ok {
+				r.runTrap(signalStatus(s, false), stdin, stdout, stderr)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// RunExitHandler runs fn sigexit, once, as the shell exits. A pending exit
+// request and its status survive the handler unless it calls exit itself.
+func (r *Runner) RunExitHandler(stdin io.Reader, stdout, stderr io.Writer) {
+	if r == nil || r.Env == nil || r.exitTrapped {
+		return
+	}
+	r.exitTrapped = true
+	requested, status := r.exitRequested, r.exitStatus
+	r.exitRequested = false
+	r.runTrap(exitHandler, stdin, stdout, stderr)
+	if !r.exitRequested {
+		r.exitRequested, r.exitStatus = requested, status
+	}
+}
+
+// runTrap calls a handler function without disturbing $status.
+func (r *Runner) runTrap(name string, stdin io.Reader, stdout, stderr io.Writer) {
+	def, ok := r.Env.GetFunc(name)
+	if !ok {
+		return
+	}
+	saved := r.Env.Get("status")
+	sh := r.shell()
+	sh.inTrap = true
+	r.runFuncCall(def, []string{name}, &ExecPlan{}, r.Env, stdin, stdout, stderr, r.fds, false)
+	sh.inTrap = false
+	r.Env.Set("status", saved)
+}
+
+// withoutExitHandler drops fn sigexit from an exported environment, for
+// subshells that must not run the parent's handler.
+func withoutExitHandler(environ []string) []string {
+	out := environ[:0:0]
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, envFnPrefix+exitHandler+"=") {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func runSignalInput(t *testing.T, r *Runner, input string, out io.Writer) Result {
+	t.Helper()
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, r.Env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	return r.RunPlan(plan, strings.NewReader(""), out, io.Discard)
+}
+
+func TestSignalHandlerRuns(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	var out bytes.Buffer
+	input := "fn sigusr1 { echo caught }\nsh -c 'kill -USR1 $PPID; sleep 0.2'\necho after\nfn sigusr1\n"
+	runSignalInput(t, r, input, &out)
+	if out.String() != "caught\nafter\n" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+	if len(r.sigWatched) != 0 {
+		t.Fatalf("expected no watched signals after removing the handler, got %v", r.sigWatched)
+	}
+}
+
+func TestSignalHandlerKeepsStatus(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	r := &Runner{Env: NewEnv(nil)}
+	var out bytes.Buffer
+	input := "fn sigusr2 { true }\nsh -c 'kill -USR2 $PPID; sleep 0.2; exit 4'\necho $status\nfn sigusr2\n"
+	runSignalInput(t, r, input, &out)
+	if out.String() != "4\n" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+}
+
+func TestExitHandler(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	var out bytes.Buffer
+	runSignalInput(t, r, "fn sigexit { echo bye $status }\nexit 3\necho nope\n", &out)
+	r.RunExitHandler(strings.NewReader(""), &out, io.Discard)
+	r.RunExitHandler(strings.NewReader(""), &out, io.Discard)
+	if out.String() != "bye 3\n" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+	if !r.ExitRequested() || r.ExitCode() != 3 {
+		t.Fatalf("expected exit 3 to survive the handler, got %d", r.ExitCode())
+	}
+}
+
+func TestExitHandlerOnNormalEnd(t *testing.T) {
+	r := &Runner{Env: NewEnv(nil)}
+	var out bytes.Buffer
+	runSignalInput(t, r, "fn sigexit { echo cleanup }\necho work\n", &out)
+	r.RunExitHandler(strings.NewReader(""), &out, io.Discard)
+	if out.String() != "work\ncleanup\n" {
+		t.Fatalf("unexpected stdout: %q", out.String())
+	}
+	if r.ExitRequested() {
+		t.Fatalf("handler should not request an exit")
+	}
+}
+
+func TestSubshellEnvDropsExitHandler(t *testing.T) {
+	environ := []string{"fn#sigexit={ echo bye }", "fn#f={ echo f }", "x=1"}
+	got := strings.Join(withoutExitHandler(environ), " ")
+	if got != "fn#f={ echo f } x=1" {
+		t.Fatalf("unexpected environment: %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Status is an rc exit status. A pipeline has one entry per stage; an
+// entry is empty or "0" on success, a decimal exit code, or a string such
+// as "sigint" or "sigsegv+core" for a process killed by a signal.
+type Status []string
+
+var (
+	statusTrue  = Status{"0"}
+	statusFalse = Status{"1"}
+)
+
+// StatusCode returns the status of a command that exited with code.
+func StatusCode(code int) Status {
+	return Status{strconv.Itoa(code)}
+}
+
+// OK reports whether the status is true: every entry is empty or zero.
+func (s Status) OK() bool {
+	for _, v := range s {
+		if v != "" && v != "0" {
+			return false
+		}
+	}
+	return true
+}
+
+// Code converts the status into a process exit code. The first failing
+// entry decides: numbers are used as they are, signal names map to
+// 128+signal, and any other string is 1.
+func (s Status) Code() int {
+	for _, v := range s {
+		if v == "" || v == "0" {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		name, _, _ := strings.Cut(v, "+")
+		if sig := unix.SignalNum(strings.ToUpper(name)); sig != 0 {
+			return 128 + int(sig)
+		}
+		return 1
+	}
+	return 0
+}
+
+// String returns the status as $status prints it, with the entries
+// joined by |.
+func (s Status) String() string {
+	return s.word()
+}
+
+// word collapses the status into one entry, as seen by an enclosing
+// pipeline.
+func (s Status) word() string {
+	switch len(s) {
+	case 0:
+		return ""
+	case 1:
+		return s[0]
+	}
+	return strings.Join(s, "|")
+}
+
+// boolStatus returns the status of a test that succeeded when ok is set.
+func boolStatus(ok bool) Status {
+	if ok {
+		return statusTrue
+	}
+	return statusFalse
+}
+
+// errStatus returns the status of a process given the error from
+// exec.Cmd.Wait or Start.
+func errStatus(err error) Status {
+	if err == nil {
+		return statusTrue
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return StatusCode(127)
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return Status{signalStatus(ws.Signal(), ws.CoreDump())}
+	}
+	return StatusCode(exitErr.ExitCode())
+}
+
+// signalStatus names a signal death the way rc does, e.g. "sigint".
+func signalStatus(sig syscall.Signal, core bool) string {
+	name := unix.SignalName(sig)
+	if name == "" {
+		name = "sig" + strconv.Itoa(int(sig))
+	}
+	name = strings.ToLower(name)
+	if core {
+		name += "+core"
+	}
+	return name
+}
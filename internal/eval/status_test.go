@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"grc/internal/parse"
+)
+
+func TestStatusOKAndCode(t *testing.T) {
+	cases := []struct {
+		status Status
+		ok     bool
+		code   int
+	}{
+		{nil, true, 0},
+		{Status{""}, true, 0},
+		{Status{"0", "0"}, true, 0},
+		{Status{"0", "3"}, false, 3},
+		{Status{"sigint"}, false, 130},
+		{Status{"sigsegv+core"}, false, 139},
+		{Status{"oops"}, false, 1},
+	}
+	for _, c := range cases {
+		if got := c.status.OK(); got != c.ok {
+			t.Fatalf("%v.OK() = %v, want %v", c.status, got, c.ok)
+		}
+		if got := c.status.Code(); got != c.code {
+			t.Fatalf("%v.Code() = %d, want %d", c.status, got, c.code)
+		}
+	}
+}
+
+func runStatusInput(t *testing.T, input string) (*Runner, string, Result) {
+	t.Helper()
+	env := NewEnv(nil)
+	ast, err := parse.ParseAll(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseAll returned error: %v", err)
+	}
+	plan, err := BuildPlan(ast, env)
+	if err != nil {
+		t.Fatalf("BuildPlan returned error: %v", err)
+	}
+	var out bytes.Buffer
+	r := &Runner{Env: env}
+	res := r.RunPlan(plan, strings.NewReader(""), &out, io.Discard)
+	return r, out.String(), res
+}
+
+func TestStatusSubscript(t *testing.T) {
+	if !haveCmd(t, "true") || !haveCmd(t, "false") {
+		t.Skip("true or false not available")
+	}
+	_, out, _ := runStatusInput(t, "false | true | false\nif(~ $status(2) 0) echo middle ok\n")
+	if out != "middle ok\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestStatusListIsFalseInConditions(t *testing.T) {
+	if !haveCmd(t, "true") || !haveCmd(t, "false") {
+		t.Skip("true or false not available")
+	}
+	_, out, _ := runStatusInput(t, "true | false && echo and\ntrue | false || echo or\ntrue | true && echo both\n")
+	if out != "or\nboth\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestExitStringStatus(t *testing.T) {
+	r, out, res := runStatusInput(t, "exit sigint; echo nope\n")
+	if out != "" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+	if !r.ExitRequested() || strings.Join(r.ExitStatus(), " ") != "sigint" {
+		t.Fatalf("unexpected exit status: %v", r.ExitStatus())
+	}
+	if r.ExitCode() != 130 || res.Status != 130 {
+		t.Fatalf("unexpected exit code: %d, %d", r.ExitCode(), res.Status)
+	}
+}
+
+func TestExitDefaultsToStatus(t *testing.T) {
+	if !haveCmd(t, "false") {
+		t.Skip("false not available")
+	}
+	r, _, _ := runStatusInput(t, "false; exit\n")
+	if r.ExitCode() != 1 {
+		t.Fatalf("expected exit code 1, got %d", r.ExitCode())
+	}
+}
+
+func TestReturnStringStatus(t *testing.T) {
+	_, out, _ := runStatusInput(t, "fn f { return 'no such thing' }\nf\necho $status\n")
+	if out != "no such thing\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
+
+func TestStatusSignalName(t *testing.T) {
+	if !haveCmd(t, "sh") {
+		t.Skip("sh not available")
+	}
+	_, out, _ := runStatusInput(t, "sh -c 'kill -9 $$'\necho $status\n")
+	if out != "sigkill\n" {
+		t.Fatalf("unexpected stdout: %q", out)
+	}
+}
@@ -0,0 +1,241 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"grc/internal/parse"
+)
+
+// TraceEvent is one line of the JSON trace: a plan node that ran. Events
+// are written when the node finishes, so the events nested in a node come
+// before it.
+type TraceEvent struct {
+	Kind   string    `json:"kind"` // cmd, builtin, function, assign, if, for, pipe, ...
+	Argv   []string  `json:"argv,omitempty"`
+	Line   int       `json:"line,omitempty"` // first word of the node
+	Col    int       `json:"col,omitempty"`
+	Stage  int       `json:"stage,omitempty"` // 1-based position in a pipeline
+	Pid    int       `json:"pid,omitempty"`
+	Pgid   int       `json:"pgid,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Status string    `json:"status"`
+	Depth  int       `json:"depth"`
+}
+
+// tracer is the JSON trace state a runner shares with its child and
+// nested runners: the file $tracefile names. The nesting depth is kept by
+// each runner, and a runner made for a pipeline stage starts below the
+// stage's event.
+type tracer struct {
+	mu     sync.Mutex
+	name   string
+	out    io.Writer
+	closer io.Closer
+}
+
+// traceSpan is a trace event being timed. Its methods do nothing on a nil
+// span, which is what the runner has when no trace is written.
+type traceSpan struct {
+	t      *tracer
+	w      io.Writer
+	r      *Runner // whose depth the span holds levels of
+	levels int
+	ev     TraceEvent
+}
+
+// traceWriter returns where JSON events go: TraceJSON, or the file named
+// by $tracefile, opened for appending on first use. It returns nil when
+// neither is set or the file cannot be opened, which is reported once on
+// stderr.
+func (r *Runner) traceWriter(stderr io.Writer) io.Writer {
+	if r.TraceJSON != nil {
+		return r.TraceJSON
+	}
+	if r.tracer == nil || r.Env == nil {
+		return nil
+	}
+	name := r.Env.Get("tracefile")
+	if len(name) == 0 || name[0] == "" {
+		return nil
+	}
+	t := r.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.name == name[0] {
+		return t.out
+	}
+	if t.closer != nil {
+		_ = t.closer.Close()
+	}
+	t.name, t.out, t.closer = name[0], nil, nil
+	f, err := r.FileSystem().OpenFile(name[0], os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		fmt.Fprintf(stderr, "rc: tracefile: %v\n", err)
+		return nil
+	}
+	w, ok := f.(io.Writer)
+	if !ok {
+		_ = f.Close()
+		return nil
+	}
+	t.out, t.closer = w, f
+	return w
+}
+
+// CloseTrace closes the file opened for $tracefile, if any. A later event
+// opens it again.
+func (r *Runner) CloseTrace() error {
+	t := r.tracer
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	if t.closer != nil {
+		err = t.closer.Close()
+	}
+	t.name, t.out, t.closer = "", nil, nil
+	return err
+}
+
+// traceBegin starts timing p as an event of the given kind, one level
+// below the events already open. It returns nil when no trace is written.
+func (r *Runner) traceBegin(p *ExecPlan, kind string, stderr io.Writer) *traceSpan {
+	if kind == "" {
+		return nil
+	}
+	w := r.traceWriter(stderr)
+	if w == nil || r.tracer == nil {
+		return nil
+	}
+	depth := r.traceDepth
+	r.traceDepth++
+	return &traceSpan{t: r.tracer, w: w, r: r, levels: 1, ev: TraceEvent{
+		Kind:  kind,
+		Line:  p.Pos.Line,
+		Col:   p.Pos.Col,
+		Start: time.Now(),
+		Depth: depth,
+	}}
+}
+
+// traceStage starts timing stage n of the pipeline whose span is open,
+// at the level that span reserved for its stages.
+func (r *Runner) traceStage(p *ExecPlan, n int, stderr io.Writer) *traceSpan {
+	w := r.traceWriter(stderr)
+	if w == nil || r.tracer == nil {
+		return nil
+	}
+	return &traceSpan{t: r.tracer, w: w, ev: TraceEvent{
+		Kind:  traceKind(p),
+		Line:  p.Pos.Line,
+		Col:   p.Pos.Col,
+		Stage: n,
+		Start: time.Now(),
+		Depth: r.traceDepth - 1,
+	}}
+}
+
+// traceKind names the kind of event p is traced as, or returns "" for a
+// node that is not traced. Commands are refined once it is known what
+// they run.
+func traceKind(p *ExecPlan) string {
+	if p.Kind == PlanNoop {
+		return ""
+	}
+	return strings.ToLower(planKindName(p.Kind))
+}
+
+// nest reserves a further level of depth, below the span's own, for
+// events such as pipeline stages that belong to it.
+func (s *traceSpan) nest() {
+	if s == nil {
+		return
+	}
+	s.r.traceDepth++
+	s.levels++
+}
+
+func (s *traceSpan) setKind(kind string) {
+	if s != nil {
+		s.ev.Kind = kind
+	}
+}
+
+func (s *traceSpan) setArgv(argv []string) {
+	if s != nil {
+		s.ev.Argv = append([]string(nil), argv...)
+	}
+}
+
+func (s *traceSpan) setProcess(pid, pgid int) {
+	if s != nil {
+		s.ev.Pid, s.ev.Pgid = pid, pgid
+	}
+}
+
+// end finishes the span with status and writes its event, once. A span
+// holding levels of depth ends on the goroutine of its runner; those of
+// pipeline stages hold none and may end on any.
+func (s *traceSpan) end(status Status) {
+	if s == nil || !s.ev.End.IsZero() {
+		return
+	}
+	s.ev.End = time.Now()
+	s.ev.Status = status.String()
+	if s.levels > 0 {
+		s.r.traceDepth -= s.levels
+	}
+	line, err := json.Marshal(s.ev)
+	if err != nil {
+		return
+	}
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	_, _ = s.w.Write(append(line, '\n'))
+}
+
+// commandGroup returns the process group c was started in as pid, or 0
+// for a process without a pid.
+func commandGroup(c *Command, pid int) int {
+	switch {
+	case pid == 0:
+		return 0
+	case !c.Setpgid:
+		return unix.Getpgrp()
+	case c.Pgid != 0:
+		return c.Pgid
+	}
+	return pid
+}
+
+// firstPos returns the position of the first token of n. The lexer
+// records positions only on words, pipes and redirections, so the
+// operands are searched before the node itself.
+func firstPos(n *parse.Node) parse.Pos {
+	if n == nil {
+		return parse.Pos{}
+	}
+	if pos := firstPos(n.Left); pos.Line > 0 {
+		return pos
+	}
+	for _, c := range n.List {
+		if pos := firstPos(c); pos.Line > 0 {
+			return pos
+		}
+	}
+	if n.Pos.Line > 0 {
+		return n.Pos
+	}
+	return firstPos(n.Right)
+}
@@ -2,6 +2,8 @@ package eval
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -26,3 +28,63 @@ func TestTraceBuiltinPwd(t *testing.T) {
 		t.Fatalf("unexpected trace: %q", trace.String())
 	}
 }
+
+func TestTraceJSON(t *testing.T) {
+	fake := &FakeExecutor{Programs: map[string]FakeProgram{"build": {Status: Status{"2"}}}}
+	var trace bytes.Buffer
+	r := &Runner{Env: NewEnv(nil), Executor: fake, TraceJSON: &trace}
+	// slurp ends only once the first stage has closed the pipe, which
+	// fixes the order of the two stages' events.
+	r.SetBuiltin("slurp", func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+		_, _ = io.Copy(io.Discard, stdin)
+		return 0
+	})
+	runExecutorInput(t, r, "fn f { build $* }\nfn g { slurp; f c }\nx=(a b)\nif (~ a a) f $x | g\n", io.Discard)
+
+	var got []string
+	dec := json.NewDecoder(&trace)
+	for dec.More() {
+		var ev TraceEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("Decode returned error: %v", err)
+		}
+		if ev.End.Before(ev.Start) {
+			t.Fatalf("event ends before it starts: %+v", ev)
+		}
+		if ev.Kind == "cmd" && (ev.Pid != 0 || ev.Pgid != 0) {
+			t.Fatalf("unexpected process: %+v", ev)
+		}
+		got = append(got, fmt.Sprintf("%s %q %d:%d s%d %s d%d", ev.Kind, ev.Argv, ev.Line, ev.Col, ev.Stage, ev.Status, ev.Depth))
+	}
+	want := []string{
+		`fndef [] 1:4 s0 0 d0`,
+		`fndef [] 2:4 s0 0 d0`,
+		`assign ["x" "a" "b"] 3:1 s0 0 d0`,
+		`match [] 4:7 s0 0 d1`,
+		`cmd ["build" "a" "b"] 1:8 s0 2 d3`,
+		`function ["f" "a" "b"] 4:12 s1 2 d2`,
+		`builtin ["slurp"] 2:8 s0 0 d3`,
+		`cmd ["build" "c"] 1:8 s0 2 d4`,
+		`function ["f" "c"] 2:15 s0 2 d3`,
+		`function ["g"] 4:19 s2 2 d2`,
+		`pipe [] 4:12 s0 2|2 d1`,
+		`if [] 4:7 s0 2|2 d0`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected events:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestTraceFileVar(t *testing.T) {
+	mem := NewMemFS()
+	r := &Runner{Env: NewEnv(nil), FS: mem}
+	runExecutorInput(t, r, "pwd\ntracefile=/trace.json\npwd\ntracefile=()\npwd\n", io.Discard)
+	data, err := mem.ReadFile("/trace.json")
+	if err != nil {
+		t.Fatalf("ReadFile returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"argv":["pwd"],"line":3`) || !strings.Contains(lines[1], `"argv":["tracefile"],"line":4`) {
+		t.Fatalf("unexpected trace: %q", data)
+	}
+}
@@ -0,0 +1,38 @@
+package eval
+
+import "golang.org/x/sys/unix"
+
+// SaveTerminalModes records the current modes of the shell's terminal as
+// its own. They are put back whenever the shell takes the terminal from a
+// job, so a program that dies or stops in raw mode leaves the terminal
+// usable.
+func (r *Runner) SaveTerminalModes() {
+	if r == nil {
+		return
+	}
+	r.shellModes = r.terminalModes()
+}
+
+// terminalModes returns the current modes of the shell's terminal, or nil
+// when there is none.
+func (r *Runner) terminalModes() *unix.Termios {
+	if !r.Interactive || r.TTYFD <= 0 {
+		return nil
+	}
+	modes, err := unix.IoctlGetTermios(r.TTYFD, unix.TCGETS)
+	if err != nil {
+		return nil
+	}
+	return modes
+}
+
+// setTerminalModes applies modes to the shell's terminal once pending
+// output is written.
+func (r *Runner) setTerminalModes(modes *unix.Termios) {
+	if modes == nil || !r.Interactive || r.TTYFD <= 0 {
+		return
+	}
+	if err := unix.IoctlSetTermios(r.TTYFD, unix.TCSETSW, modes); err != nil {
+		r.tracef("tcsetattr failed: %v\n", err)
+	}
+}
@@ -9,31 +9,38 @@ import (
 	"syscall"
 )
 
-func resolvePath(name string, env *Env, verbose bool, stderr io.Writer) (string, bool) {
+// resolvePath finds the executable name in the runner's $path and file
+// system, reporting on stderr when verbose and it cannot be found.
+func resolvePath(name string, r *Runner, verbose bool, stderr io.Writer) (string, bool) {
+	path, ok := lookPath(r.FileSystem(), name, pathList(r.Env))
+	if !ok && name != "" && verbose && stderr != nil {
+		fmt.Fprintf(stderr, "rc: cannot find `%s`\n", name)
+	}
+	return path, ok
+}
+
+// lookPath finds the executable name in fsys, in the directories of path,
+// or as it is when it contains a slash. An empty directory is the current
+// one.
+func lookPath(fsys FS, name string, path []string) (string, bool) {
 	if name == "" {
 		return "", false
 	}
 	if strings.ContainsRune(name, '/') {
-		if rcAccess(name) {
+		if rcAccess(fsys, name) {
 			return name, true
 		}
-		if verbose && stderr != nil {
-			fmt.Fprintf(stderr, "rc: cannot find `%s`\n", name)
-		}
 		return "", false
 	}
-	for _, dir := range pathList(env) {
+	for _, dir := range path {
 		if dir == "" {
 			dir = "."
 		}
 		full := filepath.Join(dir, name)
-		if rcAccess(full) {
+		if rcAccess(fsys, full) {
 			return full, true
 		}
 	}
-	if verbose && stderr != nil {
-		fmt.Fprintf(stderr, "rc: cannot find `%s`\n", name)
-	}
 	return "", false
 }
 
@@ -49,8 +56,11 @@ func pathList(env *Env) []string {
 	return []string{""}
 }
 
-func rcAccess(path string) bool {
-	info, err := os.Stat(path)
+// rcAccess reports whether path is a regular file the shell may execute.
+// Files with no owner, as in a MemFS, are executable by anyone when any
+// execute bit is set.
+func rcAccess(fsys FS, path string) bool {
+	info, err := fsys.Stat(path)
 	if err != nil {
 		return false
 	}
@@ -60,13 +70,10 @@ func rcAccess(path string) bool {
 	mode := info.Mode().Perm()
 	uid := os.Geteuid()
 	gid := os.Getegid()
-	if uid == 0 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uid == 0 {
 		return mode&0o111 != 0
 	}
-	var st syscall.Stat_t
-	if err := syscall.Stat(path, &st); err != nil {
-		return false
-	}
 	switch {
 	case int(st.Uid) == uid:
 		return mode&0o100 != 0
@@ -0,0 +1,184 @@
+// Package history keeps the command history shared by grc sessions.
+//
+// The history file holds one entry per line: the time in Unix seconds, the
+// exit status, the working directory and the command, separated by tabs.
+// Backslash, tab and newline inside a field are escaped, so multi-line
+// commands keep one line. Lines without that form, such as those of a
+// plain history file, are read as commands with no other details.
+//
+// Every access takes a lock on the file, so concurrent shells append to
+// the same history instead of overwriting each other's entries.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Entry is one command of the history.
+type Entry struct {
+	Time   time.Time
+	Status string
+	Dir    string
+	Line   string
+}
+
+// Dedup selects which duplicate commands are dropped when an entry is
+// added.
+type Dedup int
+
+const (
+	// DedupAdjacent drops a command equal to the one just before it.
+	DedupAdjacent Dedup = iota
+	// DedupNone keeps every command.
+	DedupNone
+	// DedupAll removes earlier copies of a command, keeping the newest.
+	DedupAll
+)
+
+// ParseDedup returns the mode named adjacent, none or all.
+func ParseDedup(name string) (Dedup, bool) {
+	switch name {
+	case "adjacent", "":
+		return DedupAdjacent, true
+	case "none":
+		return DedupNone, true
+	case "all":
+		return DedupAll, true
+	}
+	return DedupAdjacent, false
+}
+
+// Store is a history file.
+type Store struct {
+	Path  string
+	Max   int // entries kept; 0 means no limit
+	Dedup Dedup
+}
+
+// Load returns the entries of the file, oldest first. A missing file has
+// no entries.
+func (s *Store) Load() ([]Entry, error) {
+	f, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH); err != nil {
+		return nil, err
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	return read(f)
+}
+
+// Add records e. The file is rewritten in place when deduplication or the
+// size cap drops older entries; otherwise e is appended.
+func (s *Store) Add(e Entry) error {
+	if strings.TrimSpace(e.Line) == "" {
+		return nil
+	}
+	f, err := os.OpenFile(s.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	entries, err := read(f)
+	if err != nil {
+		return err
+	}
+	if s.Dedup == DedupAdjacent && len(entries) > 0 && entries[len(entries)-1].Line == e.Line {
+		return nil
+	}
+	kept := entries
+	if s.Dedup == DedupAll {
+		kept = entries[:0:0]
+		for _, old := range entries {
+			if old.Line != e.Line {
+				kept = append(kept, old)
+			}
+		}
+	}
+	kept = append(kept, e)
+	if s.Max > 0 && len(kept) > s.Max {
+		kept = kept[len(kept)-s.Max:]
+	}
+	if len(kept) == len(entries)+1 {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		_, err := f.WriteString(format(e))
+		return err
+	}
+	var buf bytes.Buffer
+	for _, k := range kept {
+		buf.WriteString(format(k))
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf.Bytes(), 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if e, ok := parse(sc.Text()); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
+
+func parse(line string) (Entry, bool) {
+	if strings.TrimSpace(line) == "" {
+		return Entry{}, false
+	}
+	fields := strings.SplitN(line, "\t", 4)
+	if len(fields) == 4 {
+		if sec, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			return Entry{
+				Time:   time.Unix(sec, 0),
+				Status: unescape(fields[1]),
+				Dir:    unescape(fields[2]),
+				Line:   unescape(fields[3]),
+			}, true
+		}
+	}
+	return Entry{Line: line}, true
+}
+
+func format(e Entry) string {
+	var sec int64
+	if !e.Time.IsZero() {
+		sec = e.Time.Unix()
+	}
+	return strconv.FormatInt(sec, 10) + "\t" + escape(e.Status) + "\t" + escape(e.Dir) + "\t" + escape(e.Line) + "\n"
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+)
+
+func escape(s string) string   { return escaper.Replace(s) }
+func unescape(s string) string { return unescaper.Replace(s) }
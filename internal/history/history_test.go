@@ -0,0 +1,105 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func lines(t *testing.T, s *Store) []string {
+	t.Helper()
+	entries, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Line)
+	}
+	return out
+}
+
+func add(t *testing.T, s *Store, line string) {
+	t.Helper()
+	if err := s.Add(Entry{Time: time.Unix(100, 0), Status: "0", Dir: "/tmp", Line: line}); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := &Store{Path: filepath.Join(t.TempDir(), "history")}
+	if got := lines(t, s); len(got) != 0 {
+		t.Fatalf("expected no entries, got %q", got)
+	}
+	want := Entry{Time: time.Unix(1700000000, 0), Status: "0|sigpipe", Dir: "/a\tb", Line: "for(i in 1 2) {\n\techo $i \\\n}"}
+	if err := s.Add(want); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+	entries, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].Time.Equal(want.Time) || entries[0].Status != want.Status ||
+		entries[0].Dir != want.Dir || entries[0].Line != want.Line {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestPlainLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("echo old\n\nls -l\n"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	s := &Store{Path: path}
+	add(t, s, "echo new")
+	if got := strings.Join(lines(t, s), ","); got != "echo old,ls -l,echo new" {
+		t.Fatalf("unexpected lines: %q", got)
+	}
+}
+
+func TestDedupAndCap(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		store Store
+		want  string
+	}{
+		{Store{Dedup: DedupAdjacent}, "a,b,a,c"},
+		{Store{Dedup: DedupNone}, "a,b,b,a,c,c"},
+		{Store{Dedup: DedupAll}, "b,a,c"},
+		{Store{Dedup: DedupNone, Max: 3}, "a,c,c"},
+	}
+	for i, c := range cases {
+		s := c.store
+		s.Path = filepath.Join(dir, string(rune('a'+i)))
+		for _, line := range []string{"a", "b", "b", "a", "c", "c"} {
+			add(t, &s, line)
+		}
+		if got := strings.Join(lines(t, &s), ","); got != c.want {
+			t.Fatalf("case %d: unexpected lines %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestConcurrentAdds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := &Store{Path: path, Dedup: DedupNone, Max: 1000}
+			for j := 0; j < 25; j++ {
+				if err := s.Add(Entry{Line: string(rune('a'+i)) + "x"}); err != nil {
+					t.Errorf("Add returned error: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := lines(t, &Store{Path: path}); len(got) != 100 {
+		t.Fatalf("expected 100 entries, got %d", len(got))
+	}
+}
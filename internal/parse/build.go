@@ -12,13 +12,13 @@ func buildCallFromSimple(n *Node) *Node {
 			if child == nil {
 				continue
 			}
-			if child.Kind == KRedir {
+			if child.Kind == KRedir || child.Kind == KDup {
 				redirs = append(redirs, child)
 				continue
 			}
 			args = append(args, child)
 		}
-	} else if n.Kind == KRedir {
+	} else if n.Kind == KRedir || n.Kind == KDup {
 		redirs = append(redirs, n)
 	} else {
 		args = append(args, n)
@@ -21,7 +21,11 @@ func formatNode(n *Node) string {
 	case KSeq:
 		return joinNonEmpty([]string{formatNode(n.Left), formatNode(n.Right)}, "; ")
 	case KPipe:
-		return joinNonEmpty([]string{formatNode(n.Left), formatNode(n.Right)}, " | ")
+		op := " | "
+		if n.I1 != 1 || n.I2 != 0 {
+			op = fmt.Sprintf(" |[%d=%d] ", n.I1, n.I2)
+		}
+		return joinNonEmpty([]string{formatNode(n.Left), formatNode(n.Right)}, op)
 	case KAnd:
 		return joinNonEmpty([]string{formatNode(n.Left), formatNode(n.Right)}, " && ")
 	case KOr:
@@ -33,6 +37,9 @@ func formatNode(n *Node) string {
 	case KSubshell:
 		return "@ " + formatNode(n.Left)
 	case KBrace:
+		if n.Left != nil && n.Left.Kind == KBrace {
+			return joinNonEmpty([]string{formatNode(n.Left), formatNode(n.Right)}, " ")
+		}
 		return "{ " + formatNode(n.Left) + " }"
 	case KParen:
 		return "(" + formatNode(n.Left) + ")"
@@ -57,6 +64,10 @@ func formatNode(n *Node) string {
 		return "case " + formatWords(n.Left) + ";"
 	case KMatch:
 		return "~ " + formatNode(n.Left) + " " + formatNode(n.Right)
+	case KFnDef:
+		return joinNonEmpty([]string{"fn", formatWords(n.Left), formatNode(n.Right)}, " ")
+	case KFnRm:
+		return "fn " + formatWords(n.Left)
 	case KCall:
 		return formatCall(n)
 	case KPre:
@@ -117,6 +128,9 @@ func formatWord(n *Node) string {
 	}
 	switch n.Kind {
 	case KWord:
+		if n.I1 != 0 {
+			return Quote(n.Tok)
+		}
 		return quoteIfNeeded(n.Tok)
 	case KConcat:
 		return formatWord(n.Left) + "^" + formatWord(n.Right)
@@ -154,11 +168,18 @@ func formatRedir(n *Node) string {
 	if n == nil {
 		return ""
 	}
+	if len(n.List) > 0 {
+		var parts []string
+		for _, child := range n.List {
+			parts = append(parts, formatNode(child))
+		}
+		return strings.Join(parts, " ")
+	}
 	fd := ""
 	if n.I1 >= 0 {
 		fd = fmt.Sprintf("[%d]", n.I1)
 	}
-	return n.Tok + fd + " " + formatWord(n.Right)
+	return withCmd(n.Left, n.Tok+fd+" "+formatWord(n.Right))
 }
 
 func formatDup(n *Node) string {
@@ -170,9 +191,17 @@ func formatDup(n *Node) string {
 		op = ">"
 	}
 	if n.I2 < 0 {
-		return fmt.Sprintf("%s[%d=]", op, n.I1)
+		return withCmd(n.Left, fmt.Sprintf("%s[%d=]", op, n.I1))
+	}
+	return withCmd(n.Left, fmt.Sprintf("%s[%d=%d]", op, n.I1, n.I2))
+}
+
+// withCmd prefixes a redirection with the command it wraps, if any.
+func withCmd(cmd *Node, redir string) string {
+	if cmd == nil {
+		return redir
 	}
-	return fmt.Sprintf("%s[%d=%d]", op, n.I1, n.I2)
+	return joinNonEmpty([]string{formatNode(cmd), redir}, " ")
 }
 
 func formatNmpipe(n *Node) string {
@@ -186,6 +215,16 @@ func formatNmpipe(n *Node) string {
 	return n.Left.Tok + fd + "{ " + formatNode(n.Right) + " }"
 }
 
+// Quote returns s as a single rc word that reads back as the literal
+// string s, quoting it when it is empty, holds special or glob characters,
+// or would be taken for a keyword.
+func Quote(s string) string {
+	if _, ok := keywordToken(s); ok || strings.ContainsAny(s, "*?") {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return quoteIfNeeded(s)
+}
+
 func quoteIfNeeded(s string) string {
 	if s == "" {
 		return "''"
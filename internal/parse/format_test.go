@@ -0,0 +1,90 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatRedirKeepsCommand(t *testing.T) {
+	cases := map[string]string{
+		"echo hi > f\n":    "echo hi > f",
+		"echo hi >[4=1]\n": "echo hi >[4=1]",
+		"echo hi >[7=]\n":  "echo hi >[7=]",
+		"{ a } >[2] err\n": "{ a } >[2] err",
+		"cat <[3] in >o\n": "cat <[3] in > o",
+	}
+	for input, want := range cases {
+		node, err := ParseAll(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("ParseAll(%q) returned error: %v", input, err)
+		}
+		got, err := Format(node)
+		if err != nil {
+			t.Fatalf("Format(%q) returned error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("Format(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestFormatPipeFDs(t *testing.T) {
+	cases := map[string]string{
+		"a | b\n":      "a | b",
+		"a |[2] b\n":   "a |[2=0] b",
+		"a |[0=0] b\n": "a |[0=0] b",
+	}
+	for input, want := range cases {
+		node, err := ParseAll(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("ParseAll(%q) returned error: %v", input, err)
+		}
+		got, err := Format(node)
+		if err != nil {
+			t.Fatalf("Format(%q) returned error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("Format(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestFormatFunctionsAndQuotes(t *testing.T) {
+	cases := map[string]string{
+		"fn f { echo $1 }\n":      "fn f { echo $1 }",
+		"fn f\n":                  "fn f",
+		"echo '*' ? 'a b'\n":      "echo '*' ? 'a b'",
+		"echo 'if' for\n":         "echo 'if' for",
+		"echo 'it''s'\n":          "echo 'it''s'",
+		"fn g { ls *.go | wc }\n": "fn g { ls *.go | wc }",
+	}
+	for input, want := range cases {
+		node, err := ParseAll(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("ParseAll(%q) returned error: %v", input, err)
+		}
+		got, err := Format(node)
+		if err != nil {
+			t.Fatalf("Format(%q) returned error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("Format(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	cases := map[string]string{
+		"":     "''",
+		"a":    "a",
+		"a b":  "'a b'",
+		"*.go": "'*.go'",
+		"if":   "'if'",
+		"it's": "'it''s'",
+	}
+	for input, want := range cases {
+		if got := Quote(input); got != want {
+			t.Fatalf("Quote(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
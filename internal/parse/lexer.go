@@ -33,6 +33,16 @@ type Lexer struct {
 	pendingHere *Node
 	hereMarker  string
 	hereQuoted  bool
+
+	// start is where the rune that began the last token was read.
+	start Pos
+
+	// contEOF is set when a backslash-newline is the last thing in the
+	// input.
+	contEOF bool
+
+	// openQuote is where the quote opened when the input ends inside one.
+	openQuote *Pos
 }
 
 type lexRune struct {
@@ -74,12 +84,16 @@ func (lx *Lexer) Lex(lval *grcSymType) int {
 	}
 	for {
 		r, line, col, err := lx.readRune()
+		lx.start = Pos{Line: line, Col: col}
 		if err != nil {
 			if lx.endSent {
 				return 0
 			}
 			if lx.hereMarker != "" {
-				lx.Error("heredoc incomplete")
+				lx.incomplete("heredoc incomplete")
+			}
+			if lx.contEOF {
+				lx.incomplete("line continuation at end of input")
 			}
 			lx.endSent = true
 			return END
@@ -90,6 +104,7 @@ func (lx *Lexer) Lex(lval *grcSymType) int {
 			next, _, _, err := lx.peekRune()
 			if err == nil && next == '\n' {
 				_, _, _, _ = lx.readRune()
+				lx.contEOF = lx.atEOF()
 				lx.sawSpace = true
 				lx.prevWasDollar = false
 				lx.wordState = wordNW
@@ -164,7 +179,8 @@ func (lx *Lexer) Lex(lval *grcSymType) int {
 		case '\'':
 			text, ok := lx.readSingleQuoted()
 			if !ok {
-				lx.Error("unterminated quote")
+				lx.openQuote = &Pos{Line: line, Col: col}
+				lx.incomplete("unterminated quote")
 				return 0
 			}
 			node := W(text)
@@ -235,8 +251,24 @@ func (lx *Lexer) Lex(lval *grcSymType) int {
 }
 
 func (lx *Lexer) Error(s string) {
+	if lx.endSent {
+		lx.incomplete(s)
+		return
+	}
+	if lx.Err == nil {
+		lx.Err = &Error{Line: lx.start.Line, Msg: s}
+	}
+}
+
+func (lx *Lexer) atEOF() bool {
+	_, _, _, err := lx.peekRune()
+	return err != nil
+}
+
+// incomplete records an error caused by the input ending too soon.
+func (lx *Lexer) incomplete(s string) {
 	if lx.Err == nil {
-		lx.Err = errors.New(s)
+		lx.Err = &Error{Line: lx.start.Line, Msg: s, incomplete: true}
 	}
 }
 
@@ -276,6 +308,7 @@ func (lx *Lexer) readWordTail(first rune) string {
 			next, _, _, err := lx.peekRune()
 			if err == nil && next == '\n' {
 				_, _, _, _ = lx.readRune()
+				lx.contEOF = lx.atEOF()
 				lx.sawSpace = true
 				break
 			}
@@ -304,6 +337,7 @@ func (lx *Lexer) readIdentTail(first rune) string {
 			next, _, _, err := lx.peekRune()
 			if err == nil && next == '\n' {
 				_, _, _, _ = lx.readRune()
+				lx.contEOF = lx.atEOF()
 				lx.sawSpace = true
 				break
 			}
@@ -559,7 +593,7 @@ func (lx *Lexer) finishHereDoc() {
 	}
 	content, ok := lx.readHereDoc(lx.hereMarker)
 	if !ok {
-		lx.Error("heredoc incomplete")
+		lx.incomplete("heredoc incomplete")
 		return
 	}
 	if lx.hereQuoted {
@@ -1,10 +1,31 @@
 package parse
 
 import (
+	"errors"
 	"fmt"
 	"io"
 )
 
+// ErrIncomplete is matched, with errors.Is, by the error Parse and ParseAll
+// return when the input ends inside a construct that more input could
+// finish: an open brace, parenthesis or quote, a trailing operator such as
+// | or &&, an if or while waiting for its body, a line continuation or a
+// here document without its end marker.
+var ErrIncomplete = errors.New("incomplete input")
+
+// Error is a syntax error and the line it was found on. Errors caused by
+// the input ending too soon match ErrIncomplete.
+type Error struct {
+	Line int
+	Msg  string
+
+	incomplete bool
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+func (e *Error) Is(target error) bool { return e.incomplete && target == ErrIncomplete }
+
 // Parse reads input and returns the parsed AST.
 func Parse(rd io.Reader) (*Node, error) {
 	lx := NewLexer(rd)
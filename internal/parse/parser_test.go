@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -157,6 +158,61 @@ func TestParseRedirOutAdjacent(t *testing.T) {
 	}
 }
 
+func TestParseAllIncomplete(t *testing.T) {
+	incomplete := []string{
+		"echo a |\n",
+		"a &&\n",
+		"a ||\n",
+		"if(~ $x 1)\n",
+		"while(true)\n",
+		"for(i in a b)\n",
+		"fn f {\n",
+		"{ echo a\n",
+		"x=(a b\n",
+		"echo 'a\n",
+		"echo a \\\n",
+		"cat <<EOF\nline\n",
+		"echo a; # }\n{ echo b # }\n",
+	}
+	for _, src := range incomplete {
+		if _, err := ParseAll(strings.NewReader(src)); !errors.Is(err, ErrIncomplete) {
+			t.Fatalf("%q: expected ErrIncomplete, got %v", src, err)
+		}
+	}
+	complete := []string{
+		"echo a | wc\n",
+		"echo a # {\n",
+		"fn f { echo a }\n",
+		"cat <<EOF\nline\nEOF\n",
+		"echo 'a\nb'\n",
+	}
+	for _, src := range complete {
+		if _, err := ParseAll(strings.NewReader(src)); err != nil {
+			t.Fatalf("%q: unexpected error %v", src, err)
+		}
+	}
+	for _, src := range []string{"echo )\n", "if x\n", "}\n"} {
+		_, err := ParseAll(strings.NewReader(src))
+		if err == nil || errors.Is(err, ErrIncomplete) {
+			t.Fatalf("%q: expected a syntax error, got %v", src, err)
+		}
+	}
+}
+
+func TestParseErrorLine(t *testing.T) {
+	cases := map[string]int{
+		"echo a\necho )\n":       2,
+		"echo a\n\necho 'b\nc\n": 3,
+	}
+	for src, line := range cases {
+		_, err := ParseAll(strings.NewReader(src))
+		var perr *Error
+		if !errors.As(err, &perr) || perr.Line != line {
+			t.Fatalf("%q: expected an error on line %d, got %#v", src, line, err)
+		}
+	}
+}
+
 func isSubsequence(haystack, needle []string) bool {
 	if len(needle) == 0 {
 		return true
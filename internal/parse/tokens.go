@@ -0,0 +1,71 @@
+package parse
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Token is one lexical token of a source string together with its place
+// in it, for tools such as completion that work on partial input.
+type Token struct {
+	Tok    int    // token code: WORD, SREDIR, PIPE, '$', ...
+	Text   string // word text, with quotes removed
+	Quoted bool   // the word was single-quoted
+	Open   bool   // the quote is still open at the end of the source
+	Start  int    // byte offset of the first character
+	End    int    // byte offset just past the last character
+}
+
+// Tokens splits src into tokens with the lexer the parser uses. It stops
+// at the first lexical error, except that a quoted word left open at the
+// end of src is returned as an Open token.
+func Tokens(src string) []Token {
+	lines := []int{0, 0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	offset := func(p Pos) int {
+		if p.Line < 1 || p.Line >= len(lines) {
+			return len(src)
+		}
+		off := lines[p.Line]
+		for col := 1; col < p.Col && off < len(src); col++ {
+			_, size := utf8.DecodeRuneInString(src[off:])
+			off += size
+		}
+		return off
+	}
+
+	lx := NewLexer(strings.NewReader(src))
+	var out []Token
+	for {
+		var lval grcSymType
+		tok := lx.Lex(&lval)
+		if tok == 0 || tok == END {
+			if errors.Is(lx.Err, ErrIncomplete) && lx.openQuote != nil {
+				start := offset(*lx.openQuote)
+				out = append(out, Token{
+					Tok:    WORD,
+					Text:   strings.ReplaceAll(src[start+1:], "''", "'"),
+					Quoted: true,
+					Open:   true,
+					Start:  start,
+					End:    len(src),
+				})
+			}
+			return out
+		}
+		t := Token{Tok: tok, Start: offset(lx.start), End: offset(Pos{Line: lx.line, Col: lx.col + 1})}
+		if tok == int('^') && !strings.HasPrefix(src[t.Start:], "^") {
+			t.End = t.Start
+		}
+		if lval.node != nil && lval.node.Kind == KWord {
+			t.Text = lval.node.Tok
+			t.Quoted = lval.node.I1 != 0
+		}
+		out = append(out, t)
+	}
+}
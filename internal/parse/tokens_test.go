@@ -0,0 +1,53 @@
+package parse
+
+import "testing"
+
+func TestTokensPositions(t *testing.T) {
+	src := "cat <α.txt |[2] wc; echo $x(1) 'a b"
+	want := []struct {
+		tok  int
+		text string
+		span string
+	}{
+		{WORD, "cat", "cat"},
+		{REDIR, "", "<"},
+		{WORD, "α.txt", "α.txt"},
+		{PIPE, "", "|[2]"},
+		{WORD, "wc", "wc"},
+		{';', "", ";"},
+		{WORD, "echo", "echo"},
+		{'$', "", "$"},
+		{WORD, "x", "x"},
+		{SUB, "", "("},
+		{WORD, "1", "1"},
+		{')', "", ")"},
+		{WORD, "a b", "'a b"},
+	}
+	got := Tokens(src)
+	if len(got) != len(want) {
+		t.Fatalf("token count mismatch: got %d, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Tok != w.tok || g.Text != w.text || src[g.Start:g.End] != w.span {
+			t.Fatalf("token %d: got %d %q %q, want %d %q %q", i, g.Tok, g.Text, src[g.Start:g.End], w.tok, w.text, w.span)
+		}
+	}
+	if last := got[len(got)-1]; !last.Quoted || !last.Open {
+		t.Fatalf("expected an open quoted word, got %+v", last)
+	}
+}
+
+func TestTokensOpenQuoteInWord(t *testing.T) {
+	src := "cat a'b c"
+	got := Tokens(src)
+	if len(got) != 3 {
+		t.Fatalf("token count mismatch: got %d, want 3: %+v", len(got), got)
+	}
+	if a := got[1]; a.Text != "a" || src[a.Start:a.End] != "a" {
+		t.Fatalf("unexpected word before the quote: %+v", a)
+	}
+	if q := got[2]; q.Text != "b c" || !q.Open || src[q.Start:q.End] != "'b c" {
+		t.Fatalf("unexpected open quote: %+v", q)
+	}
+}
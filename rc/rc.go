@@ -0,0 +1,277 @@
+// Package rc embeds the grc shell in Go programs. An Interpreter parses
+// and runs rc source against an environment that persists from one call
+// to the next, so variables and functions defined by one Run are seen by
+// the next.
+package rc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"grc/internal/eval"
+	"grc/internal/parse"
+)
+
+type (
+	// Env is an rc environment: list variables and functions in a
+	// dynamic scope chain.
+	Env = eval.Env
+	// Runner executes parsed programs; builtins receive it.
+	Runner = eval.Runner
+	// Builtin is a command implemented in Go. It returns the command's
+	// exit status.
+	Builtin = eval.Builtin
+	// Status is an rc exit status, one entry per pipeline stage.
+	Status = eval.Status
+	// SyntaxError is the error returned for source that does not parse.
+	SyntaxError = parse.Error
+
+	// Executor starts external commands; set Runner().Executor to
+	// replace the default, OSExecutor.
+	Executor = eval.Executor
+	// Command is an external command ready to start.
+	Command = eval.Command
+	// Process is a started external command.
+	Process = eval.Process
+	// OSExecutor runs commands as child processes.
+	OSExecutor = eval.OSExecutor
+	// FakeExecutor runs no programs; it records commands and plays back
+	// scripted output and statuses, for tests.
+	FakeExecutor = eval.FakeExecutor
+	// FakeProgram is the scripted behaviour of a faked command.
+	FakeProgram = eval.FakeProgram
+	// FakeCall is a command a FakeExecutor was asked to start.
+	FakeCall = eval.FakeCall
+
+	// FS is the file system a Runner works in, with its working
+	// directory; set Runner().FS to replace the default, an OSFS.
+	FS = eval.FS
+	// File is a file opened by an FS.
+	File = eval.File
+	// OSFS is the operating system's file system, with a working
+	// directory of its own.
+	OSFS = eval.OSFS
+	// MemFS is a file system held in memory.
+	MemFS = eval.MemFS
+
+	// TraceEvent is one line of the JSON trace written to
+	// Runner().TraceJSON or $tracefile.
+	TraceEvent = eval.TraceEvent
+)
+
+// ErrIncomplete matches, with errors.Is, a syntax error caused by source
+// that stops inside a construct, such as an open brace or quote.
+var ErrIncomplete = parse.ErrIncomplete
+
+// ErrExited is returned by DefineFunc once the exit builtin has run.
+var ErrExited = errors.New("rc: interpreter has exited")
+
+// ErrNotFound is returned by an Executor for a program it cannot find.
+var ErrNotFound = eval.ErrNotFound
+
+// NewOSFS returns an OSFS working in dir, or in the process's directory
+// when dir is empty.
+func NewOSFS(dir string) *OSFS {
+	return eval.NewOSFS(dir)
+}
+
+// NewMemFS returns an empty in-memory file system.
+func NewMemFS() *MemFS {
+	return eval.NewMemFS()
+}
+
+// Interpreter runs rc source. Commands read Stdin and write Stdout and
+// Stderr; nil fields read nothing and discard output.
+type Interpreter struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	runner *eval.Runner
+}
+
+// New returns an interpreter with an empty environment apart from $ifs,
+// $nl and $tab.
+func New() *Interpreter {
+	env := eval.NewEnv(nil)
+	env.Set("ifs", []string{" ", "\t", "\n"})
+	env.Set("nl", []string{"\n"})
+	env.Set("tab", []string{"\t"})
+	return &Interpreter{runner: &eval.Runner{Env: env}}
+}
+
+// Runner returns the runner behind the interpreter, for settings such as
+// tracing and job control.
+func (in *Interpreter) Runner() *Runner {
+	return in.runner
+}
+
+// Env returns the top-level environment.
+func (in *Interpreter) Env() *Env {
+	return in.runner.Env
+}
+
+// ImportEnviron defines the variables and functions in environ, a list of
+// name=value entries as passed to a process.
+func (in *Interpreter) ImportEnviron(environ []string) error {
+	return eval.ImportEnv(in.runner.Env, environ)
+}
+
+// Get returns the value of the variable name.
+func (in *Interpreter) Get(name string) []string {
+	return in.runner.Env.Get(name)
+}
+
+// Set assigns vals to the variable name.
+func (in *Interpreter) Set(name string, vals []string) {
+	in.runner.Env.Set(name, vals)
+}
+
+// Unset removes the variable name.
+func (in *Interpreter) Unset(name string) {
+	in.runner.Env.Unset(name)
+}
+
+// SetArgs sets $0 and the positional parameters $*.
+func (in *Interpreter) SetArgs(argv0 string, args []string) {
+	in.runner.Env.Set("0", []string{argv0})
+	in.runner.Env.Set("*", args)
+}
+
+// SetFlag turns the shell flag c, as set by the flag builtin, on or off.
+func (in *Interpreter) SetFlag(c byte, on bool) {
+	in.runner.SetFlag(c, on)
+}
+
+// DefineFunc defines the function name with body, the rc source of its
+// body without the enclosing braces. body must parse on its own as a list
+// of commands; nothing in it runs.
+func (in *Interpreter) DefineFunc(name, body string) error {
+	if _, exited := in.Exited(); exited {
+		return ErrExited
+	}
+	if name == "" {
+		return errors.New("rc: empty function name")
+	}
+	ast, err := parse.ParseAll(strings.NewReader(body + "\n"))
+	if err != nil {
+		return err
+	}
+	in.runner.SetFunc(name, &parse.Node{Kind: parse.KBrace, Left: ast})
+	return nil
+}
+
+// Builtin registers fn as the builtin command name, replacing any builtin
+// of that name. A nil fn removes it.
+func (in *Interpreter) Builtin(name string, fn Builtin) {
+	in.runner.SetBuiltin(name, fn)
+}
+
+// Program is parsed rc source, ready to run.
+type Program struct {
+	plan *eval.ExecPlan
+}
+
+// String describes the program's execution plan, as printed by grc -p.
+func (p *Program) String() string {
+	return eval.DumpPlan(p.plan)
+}
+
+// Compile parses rd to its end. A syntax error is a *SyntaxError.
+func (in *Interpreter) Compile(rd io.Reader) (*Program, error) {
+	ast, err := parse.ParseAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := eval.BuildPlan(ast, in.runner.Env)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{plan: plan}, nil
+}
+
+// Exec runs prog and returns its status, which is also left in $status.
+func (in *Interpreter) Exec(prog *Program) Status {
+	return in.ExecContext(context.Background(), prog)
+}
+
+// ExecContext runs prog until ctx is done. A cancelled program stops
+// before its next command, its running commands are killed, and its
+// status is "interrupted".
+func (in *Interpreter) ExecContext(ctx context.Context, prog *Program) Status {
+	stdin, stdout, stderr := in.stdio()
+	return in.runner.RunPlanContext(ctx, prog.plan, stdin, stdout, stderr).List
+}
+
+// Run parses and runs src. The error is non-nil only when src cannot be
+// parsed, in which case nothing runs.
+func (in *Interpreter) Run(src string) (Status, error) {
+	return in.RunContext(context.Background(), src)
+}
+
+// RunContext parses src and runs it until ctx is done, as ExecContext
+// does.
+func (in *Interpreter) RunContext(ctx context.Context, src string) (Status, error) {
+	if !strings.HasSuffix(src, "\n") {
+		src += "\n"
+	}
+	prog, err := in.Compile(strings.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return in.ExecContext(ctx, prog), nil
+}
+
+// RunReader parses rd to its end and runs it, as Run does.
+func (in *Interpreter) RunReader(rd io.Reader) (Status, error) {
+	prog, err := in.Compile(rd)
+	if err != nil {
+		return nil, err
+	}
+	return in.Exec(prog), nil
+}
+
+// RunFile runs the script path with the positional parameters args, as
+// the . builtin does.
+func (in *Interpreter) RunFile(path string, args ...string) Status {
+	argv := append([]string{".", path}, args...)
+	stdin, stdout, stderr := in.stdio()
+	return in.runner.RunPlan(&eval.ExecPlan{Kind: eval.PlanCmd, Argv: argv}, stdin, stdout, stderr).List
+}
+
+// Exited reports whether the exit builtin has run, and the status it
+// asked to exit with. The process itself keeps running; later calls to
+// Run return that status at once without running anything.
+func (in *Interpreter) Exited() (Status, bool) {
+	return in.runner.ExitStatus(), in.runner.ExitRequested()
+}
+
+// Close runs the fn sigexit handler, if any, once.
+func (in *Interpreter) Close() {
+	stdin, stdout, stderr := in.stdio()
+	in.runner.RunExitHandler(stdin, stdout, stderr)
+	_ = in.runner.CloseTrace()
+}
+
+func (in *Interpreter) stdio() (io.Reader, io.Writer, io.Writer) {
+	stdin, stdout, stderr := in.Stdin, in.Stdout, in.Stderr
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	return stdin, stdout, stderr
+}
+
+// Incomplete reports whether src stops inside a construct that more input
+// could finish, which is when an interactive shell prompts for another
+// line.
+func Incomplete(src string) bool {
+	_, err := parse.ParseAll(strings.NewReader(src + "\n"))
+	return errors.Is(err, ErrIncomplete)
+}
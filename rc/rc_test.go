@@ -0,0 +1,94 @@
+package rc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInterpreterRun(t *testing.T) {
+	in := New()
+	var out bytes.Buffer
+	in.Stdout = &out
+	in.Builtin("say", func(stdin io.Reader, stdout, stderr io.Writer, args []string, r *Runner) int {
+		fmt.Fprintln(stdout, strings.Join(args[1:], " "))
+		return len(args) - 1
+	})
+	in.Set("who", []string{"a", "b"})
+	if err := in.DefineFunc("greet", "say hello $*"); err != nil {
+		t.Fatalf("DefineFunc returned error: %v", err)
+	}
+
+	status, err := in.Run("greet $who; x=($status done)")
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if out.String() != "hello a b\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	if got := strings.Join(in.Get("x"), " "); got != "3 done" {
+		t.Fatalf("unexpected $x: %q", got)
+	}
+	if status.String() != "0" {
+		t.Fatalf("unexpected status: %q", status)
+	}
+
+	status, err = in.RunReader(strings.NewReader("say | say x y\n"))
+	if err != nil {
+		t.Fatalf("RunReader returned error: %v", err)
+	}
+	if status.String() != "0|2" {
+		t.Fatalf("unexpected pipeline status: %q", status)
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	in := New()
+	_, err := in.Run("echo )")
+	var se *SyntaxError
+	if !errors.As(err, &se) || se.Line != 1 {
+		t.Fatalf("expected a syntax error on line 1, got %v", err)
+	}
+	if _, err := in.Run("if(true) {"); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("expected ErrIncomplete, got %v", err)
+	}
+	if !Incomplete("fn f {") || Incomplete("fn f {}") {
+		t.Fatalf("unexpected Incomplete result")
+	}
+	if err := in.DefineFunc("f", "}"); err == nil {
+		t.Fatalf("expected a bad function body to fail")
+	}
+	if err := in.DefineFunc("f", "x=1 }; fn g { y=2"); err == nil {
+		t.Fatalf("expected a body closing the function to fail")
+	}
+	if _, ok := in.Env().GetFunc("g"); ok || in.Get("x") != nil {
+		t.Fatalf("a rejected function body ran")
+	}
+
+	in.Run("exit 3; x=1")
+	status, exited := in.Exited()
+	if !exited || status.String() != "3" || in.Get("x") != nil {
+		t.Fatalf("unexpected exit: %q %v %q", status, exited, in.Get("x"))
+	}
+	if err := in.DefineFunc("f", "x=1"); !errors.Is(err, ErrExited) {
+		t.Fatalf("expected ErrExited, got %v", err)
+	}
+}
+
+func TestInterpreterExecutor(t *testing.T) {
+	in := New()
+	fake := &FakeExecutor{Programs: map[string]FakeProgram{"git": {Stdout: "main\n"}}}
+	in.Runner().Executor = fake
+	if _, err := in.Run("branch=`{git branch --show-current}"); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if got := in.Get("branch"); len(got) != 1 || got[0] != "main" {
+		t.Fatalf("unexpected $branch: %q", got)
+	}
+	if calls := fake.Calls(); len(calls) != 1 || strings.Join(calls[0].Argv, " ") != "git branch --show-current" {
+		t.Fatalf("unexpected calls: %d", len(calls))
+	}
+}
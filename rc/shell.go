@@ -0,0 +1,64 @@
+package rc
+
+import (
+	"grc/internal/eval"
+	"grc/internal/history"
+	"grc/internal/parse"
+)
+
+// This file holds what an interactive shell built on the package needs
+// beyond running source: the history file, the lexer's view of a partial
+// line for completion, and the names of the builtins.
+
+type (
+	// Job is a background or stopped job of a Runner.
+	Job = eval.Job
+	// HistoryStore is a history file.
+	HistoryStore = history.Store
+	// HistoryEntry is one command of the history.
+	HistoryEntry = history.Entry
+	// Token is a token of a source line, as returned by Tokens.
+	Token = parse.Token
+)
+
+// Token kinds besides single characters such as ';', '&', '$', '{' and
+// '(', which are their own kind.
+const (
+	TokWord     = parse.WORD     // a word
+	TokSub      = parse.SUB      // the '(' of a subscript
+	TokRedir    = parse.REDIR    // a redirection: >, >>, < or one with an fd, as >[2]
+	TokSRedir   = parse.SREDIR   // a here document or string: << or <<<
+	TokCount    = parse.COUNT    // $#
+	TokFlat     = parse.FLAT     // $"
+	TokPipe     = parse.PIPE     // |
+	TokAndAnd   = parse.ANDAND   // &&
+	TokOrOr     = parse.OROR     // ||
+	TokBang     = parse.BANG     // !
+	TokSubshell = parse.SUBSHELL // @
+	TokIf       = parse.IF       // if
+	TokWhile    = parse.WHILE    // while
+)
+
+// History returns the history file named by $history in env, sized by
+// $historysize, or nil when $history is unset.
+func History(env *Env) *HistoryStore {
+	return eval.HistoryStore(env)
+}
+
+// Tokens splits src into tokens with the lexer the parser uses. It stops
+// at the first lexical error, except that a quoted word left open at the
+// end of src is returned as an Open token.
+func Tokens(src string) []Token {
+	return parse.Tokens(src)
+}
+
+// Quote returns s as a single rc word that reads back as the literal
+// string s.
+func Quote(s string) string {
+	return parse.Quote(s)
+}
+
+// BuiltinNames returns the names of the default builtins, sorted.
+func BuiltinNames() []string {
+	return eval.BuiltinNames()
+}